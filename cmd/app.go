@@ -0,0 +1,379 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"controller/pkg/config"
+	"controller/pkg/leader"
+	"controller/pkg/logging"
+	"controller/pkg/models"
+	"controller/pkg/monitor"
+	"controller/pkg/notify"
+	"controller/pkg/state"
+	"controller/pkg/status"
+	"controller/pkg/store"
+)
+
+// AppContext 包含应用程序的共享状态
+type AppContext struct {
+	// [新增] 配置文件路径和状态目录 (命令行 --state-dir 或配置 state.dir)
+	ConfigPath string
+	StateDir   string
+	// [新增] DryRun 为 true 时只输出计划中的 DNS 变更，不调用服务商、不写结果 Gist 和历史库
+	DryRun bool
+
+	// [新增] 最近一次成功加载的配置，供后台监控等非 cron 协程读取
+	mu  sync.RWMutex
+	cfg *config.Config
+
+	// [新增] 串行化来自定时任务、文件监视和 SIGHUP 的重载; cron.spec 变化时调用 reschedule
+	reloadMu   sync.Mutex
+	reschedule func(spec string) error
+
+	// [新增] 保证同一时刻只有一次运行; ctx 在退出时被取消，进行中的运行随之中止
+	runMu        sync.Mutex
+	running      bool
+	queued       bool
+	shuttingDown bool
+	runDone      chan struct{} // 当前运行 (含排队的运行) 全部结束时关闭
+	ctx          context.Context
+	cancel       context.CancelFunc
+
+	Store   *store.Store     // [新增] 本地历史库, 未启用时为 nil
+	Monitor *monitor.Monitor // [新增] 已发布 IP 的后台监控, 未启用时为 nil
+	Status  *status.Registry // [新增] 运行状态, 供 HTTP API 查询
+	Elector *leader.Elector  // [新增] 选主, 未启用时为 nil (视为 leader)
+	State   *state.Store     // [新增] 需要在运行之间和重启后保留的状态 (state.json)
+
+	// [新增] 通知及各线路最近一次发布到 DNS 的结果 (用于对比新旧 IP)
+	Notifier  *notify.Notifier
+	published map[string]models.LineResult
+
+	// [新增] 上一次写入结果 Gist 的内容摘要，用于跳过没有变化的写入
+	lastPublish publishDigest
+}
+
+// historyPath 返回历史库路径，未配置时放在状态目录下
+func (a *AppContext) historyPath(cfg *config.Config) string {
+	if cfg.History.Path != "" {
+		return cfg.History.Path
+	}
+	return filepath.Join(a.StateDir, "history.db")
+}
+
+func (a *AppContext) setConfig(cfg *config.Config) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.cfg = cfg
+}
+
+func (a *AppContext) config() *config.Config {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.cfg
+}
+
+// publishedLines 返回各线路最近一次发布的结果 (启动时从状态文件恢复) 的副本
+func (a *AppContext) publishedLines() map[string]models.LineResult {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return maps.Clone(a.published)
+}
+
+// notePublished 记录线路新发布的结果，IP 发生变化时发送通知
+func (a *AppContext) notePublished(cfg *config.Config, key string, lr models.LineResult) {
+	a.mu.Lock()
+	prev, known := a.published[key]
+	a.published[key] = lr
+	a.mu.Unlock()
+
+	a.saveLine(cfg, key, lr)
+	if known && notify.SameIPs(prev.Active, lr.Active) {
+		return
+	}
+	var old []models.SelectedItem
+	if known {
+		old = prev.Active
+		if old == nil {
+			old = []models.SelectedItem{}
+		}
+	}
+	groupID, _, _ := models.SplitLineKey(key)
+	group, _ := cfg.DNS.Group(groupID)
+	recordName := group.RecordName()
+	a.Notifier.Notify(notify.DNSChangeEvent(key, recordName, old, lr.Active))
+}
+
+// reloadConfig 重新加载并校验配置文件，记录与当前配置的差异 (隐去密钥)，
+// 校验失败时保留上一次成功加载的配置。返回当前生效的配置。
+func (a *AppContext) reloadConfig(reason string) *config.Config {
+	a.reloadMu.Lock()
+	defer a.reloadMu.Unlock()
+
+	slog.Debug("reloading configuration", "path", a.ConfigPath, "reason", reason)
+	old := a.config()
+	cfg, err := config.Load(a.ConfigPath)
+	if err != nil {
+		// [修改] 拒绝有问题的配置，继续使用上一次成功加载的配置
+		slog.Error("failed to reload config, keeping last good config", "reason", reason, "error", err)
+		a.Notifier.Notify(notify.FailureEvent(notify.SeverityError, "config", a.ConfigPath, err))
+		return old
+	}
+	if old == nil {
+		a.setConfig(cfg)
+		return cfg
+	}
+
+	changes := config.Diff(old, cfg)
+	if len(changes) == 0 {
+		return old
+	}
+	if err := logging.Setup(cfg.Log); err != nil {
+		slog.Warn("invalid log config, keeping previous logger", "error", err)
+	}
+	if err := a.Notifier.Configure(cfg.Notify); err != nil {
+		slog.Warn("invalid notify config, keeping previous channels", "error", err)
+	}
+	slog.Info("configuration changed", "path", a.ConfigPath, "reason", reason, "changes", len(changes))
+	for _, c := range changes {
+		if c.RequiresRestart() {
+			slog.Warn("config changed, takes effect after restart", "field", c.Path, "old", c.Old, "new", c.New)
+			continue
+		}
+		slog.Info("config changed", "field", c.Path, "old", c.Old, "new", c.New)
+	}
+
+	if cfg.Cron.Spec != old.Cron.Spec && a.reschedule != nil {
+		if err := a.reschedule(cfg.Cron.Spec); err != nil {
+			slog.Error("failed to reschedule cron entry, keeping old schedule", "spec", cfg.Cron.Spec, "error", err)
+		} else {
+			slog.Info("cron entry rescheduled", "old_spec", old.Cron.Spec, "spec", cfg.Cron.Spec)
+		}
+	}
+	a.setConfig(cfg)
+	return cfg
+}
+
+// scheduledRun 是 cron 和启动时的首次运行共用的入口 (API 触发直接调用 beginRun 和 runLoop)。
+// 上一次运行尚未结束时按 run.overlap 跳过或排队，避免两次运行同时写 DNS。
+func (a *AppContext) scheduledRun() {
+	if a.beginRun() != runStarted {
+		return
+	}
+	a.runLoop()
+}
+
+// runLoop 在 beginRun 返回 runStarted 后执行任务，直到没有排队的运行
+func (a *AppContext) runLoop() {
+	for {
+		a.runOnce()
+		if !a.endRun() {
+			return
+		}
+		slog.Info("starting queued run")
+	}
+}
+
+// runStart 是 beginRun 的结果
+type runStart int
+
+const (
+	runStarted runStart = iota // 获取到运行权，调用方需执行 runLoop
+	runQueued                  // 已有运行在进行，结束后再运行一次 (overlap: queue)
+	runSkipped                 // 已有运行在进行 (overlap: skip) 或正在退出
+)
+
+// beginRun 尝试获取运行权
+func (a *AppContext) beginRun() runStart {
+	overlap := config.OverlapSkip
+	if cfg := a.config(); cfg != nil && cfg.Run.Overlap != "" {
+		overlap = cfg.Run.Overlap
+	}
+
+	a.runMu.Lock()
+	defer a.runMu.Unlock()
+	switch {
+	case a.shuttingDown:
+		slog.Info("shutting down, run not started")
+		return runSkipped
+	case !a.running:
+		a.running = true
+		a.runDone = make(chan struct{})
+		return runStarted
+	case overlap == config.OverlapQueue:
+		if !a.queued {
+			a.queued = true
+			slog.Info("previous run still in progress, run queued")
+		}
+		return runQueued
+	default:
+		slog.Warn("previous run still in progress, run skipped")
+		return runSkipped
+	}
+}
+
+// endRun 释放运行权，有排队的运行时返回 true 并由调用方继续执行
+func (a *AppContext) endRun() bool {
+	a.runMu.Lock()
+	defer a.runMu.Unlock()
+	if a.queued && !a.shuttingDown {
+		a.queued = false
+		return true
+	}
+	a.queued = false
+	a.running = false
+	close(a.runDone)
+	return false
+}
+
+// Shutdown 阻止新的运行，等待进行中的运行最多 grace 时间，超时后取消它并等待其退出
+func (a *AppContext) Shutdown(grace time.Duration) {
+	a.runMu.Lock()
+	a.shuttingDown = true
+	running, done := a.running, a.runDone
+	a.runMu.Unlock()
+
+	if running {
+		slog.Info("waiting for in-flight run to finish", "grace", grace.String())
+		select {
+		case <-done:
+		case <-time.After(grace):
+			slog.Warn("in-flight run did not finish in time, cancelling it")
+			a.cancel()
+			<-done
+		}
+	}
+	a.cancel()
+}
+
+// runOnce 执行一次完整的任务，返回本次运行的结果 (配置加载失败且没有可用的旧配置时为 nil)
+func (a *AppContext) runOnce() *status.Run {
+	// --- 1. 热重载配置 ---
+	cfg := a.reloadConfig("scheduled run")
+	if cfg == nil {
+		slog.Error("no usable config, skipping run")
+		return nil
+	}
+
+	// --- 2. 结果 Gist ID: 优先使用配置文件，其次是状态文件中自动创建的 Gist ---
+	gistID := cfg.Gist.ResultGistID
+	if gistID == "" {
+		gistID = a.State.Get().ResultGistID
+	}
+
+	// --- 3. 执行核心任务 ---
+	ctx, cancel := context.WithTimeout(a.ctx, cfg.Run.Timeout())
+	defer cancel()
+	run := a.runTask(ctx, cfg, gistID)
+	return &run
+}
+
+// newAppContext 加载配置、初始化日志并打开状态文件和历史库 (如启用)，供各子命令共用。
+// [新增] dryRun 为 true 时以只读方式打开状态文件和历史库，不创建也不修改本地文件
+func newAppContext(opts globalOptions, dryRun bool) (*AppContext, *config.Config, error) {
+	cfg, err := config.Load(opts.configPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load config %s: %w", opts.configPath, err)
+	}
+	if err := logging.Setup(cfg.Log); err != nil {
+		return nil, nil, fmt.Errorf("invalid log config: %w", err)
+	}
+	a := &AppContext{
+		ConfigPath: opts.configPath,
+		StateDir:   opts.resolveStateDir(cfg),
+		DryRun:     dryRun,
+		cfg:        cfg,
+		Status:     status.New(),
+		Notifier:   notify.New(),
+		published:  make(map[string]models.LineResult),
+	}
+	a.ctx, a.cancel = context.WithCancel(context.Background())
+	if err := a.Notifier.Configure(cfg.Notify); err != nil {
+		return nil, nil, err
+	}
+	// [新增] 状态文件，首次启动时迁移旧版本保存在状态目录或配置目录下的 result_gist_id.txt
+	openState := state.Open
+	if dryRun {
+		openState = state.OpenReadOnly
+	} else {
+		moveLegacyStateFiles(filepath.Dir(opts.configPath), a.StateDir, cfg.History.Path == "")
+	}
+	sf, err := openState(a.StateDir, opts.stateDir, filepath.Dir(opts.configPath))
+	if err != nil {
+		return nil, nil, err
+	}
+	a.State = sf
+	a.restoreState()
+	// [新增] 上次退出前未写入成功的结果 Gist 内容，在状态中标出并于下次运行时重试
+	if p, err := a.loadPendingUpload(); err != nil {
+		slog.Warn("failed to load pending upload", "error", err)
+	} else if p != nil {
+		a.notePendingUpload(p)
+		slog.Warn("found pending result gist upload, will retry on next run", "path", a.pendingUploadPath(), "saved_at", p.SavedAt)
+	}
+	// [新增] 历史库在启动时打开一次 (bbolt 为独占文件锁)，修改 history 配置需重启生效
+	if cfg.History.Enabled && dryRun {
+		// dry-run 只读取历史样本，服务运行中时库被锁定，此时只使用本批次结果打分
+		path := a.historyPath(cfg)
+		st, err := store.OpenReadOnly(path, time.Second)
+		if err != nil {
+			slog.Warn("history store unavailable in dry-run, scoring without history", "error", err)
+		} else {
+			a.Store = st
+		}
+	} else if cfg.History.Enabled {
+		path := a.historyPath(cfg)
+		st, err := store.Open(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		a.Store = st
+		slog.Info("history store opened", "path", path)
+	}
+	return a, cfg, nil
+}
+
+// Close 释放 AppContext 持有的资源
+func (a *AppContext) Close() {
+	if a.Store != nil {
+		a.Store.Close()
+	}
+}
+
+// moveLegacyStateFiles 将旧版本默认保存在配置目录下的运行时文件移动到状态目录，
+// 状态目录中已有同名文件时不覆盖。withHistory 为 false 时 (history.path 已显式配置) 不移动历史库
+func moveLegacyStateFiles(configDir, stateDir string, withHistory bool) {
+	if filepath.Clean(configDir) == filepath.Clean(stateDir) {
+		return
+	}
+	names := []string{state.FileName, "pending_upload.json"}
+	if withHistory {
+		names = append(names, "history.db")
+	}
+	for _, name := range names {
+		from, to := filepath.Join(configDir, name), filepath.Join(stateDir, name)
+		if _, err := os.Stat(from); err != nil {
+			continue
+		}
+		if _, err := os.Stat(to); err == nil {
+			slog.Warn("legacy state file left in config dir, state dir already has one", "path", from, "state_dir", stateDir)
+			continue
+		}
+		if err := os.MkdirAll(stateDir, 0755); err != nil {
+			slog.Warn("failed to create state dir", "path", stateDir, "error", err)
+			return
+		}
+		if err := os.Rename(from, to); err != nil {
+			slog.Warn("failed to move legacy state file to state dir", "from", from, "to", to, "error", err)
+			continue
+		}
+		slog.Info("moved legacy state file to state dir", "from", from, "to", to)
+	}
+}
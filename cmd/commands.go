@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"controller/pkg/config"
+	"controller/pkg/models"
+	"controller/pkg/notify"
+	"controller/pkg/status"
+	"controller/pkg/store"
+)
+
+// runCommand 执行一次任务后退出，失败时返回非零退出码
+func runCommand(opts globalOptions, args []string) int {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	// --once 没有效果 (run 总是只运行一次)，仅为兼容已有脚本保留
+	fs.Bool("once", true, "deprecated: no effect, run always runs the task once")
+	dryRun := fs.Bool("dry-run", false, "print planned DNS changes without calling any provider or writing the result Gist")
+	fs.Parse(args)
+
+	appCtx, cfg, err := newAppContext(opts, *dryRun)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer appCtx.Close()
+	appCtx.Notifier.SetDryRun(*dryRun)
+
+	// [新增] 启用选主时同样先获取锁: 其他实例持有锁时本次只做优选，不写 DNS 和结果 Gist
+	if !*dryRun {
+		elector, err := newElector(cfg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to set up leader election: %v\n", err)
+			return 1
+		}
+		if elector != nil {
+			appCtx.Elector = elector
+			elector.Start(context.Background())
+			defer elector.Stop()
+			if !elector.IsLeader() {
+				slog.Warn("another instance holds the leader lock, running as standby", "identity", elector.Identity())
+			}
+		}
+	}
+
+	// Ctrl-C / SIGTERM 取消进行中的运行
+	sigCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	context.AfterFunc(sigCtx, appCtx.cancel)
+
+	run := appCtx.runOnce()
+	if run == nil || run.Outcome == status.OutcomeFailed {
+		return 1
+	}
+	return 0
+}
+
+// validateCommand 严格解析并校验配置文件，列出所有问题
+func validateCommand(opts globalOptions, args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	fs.Parse(args)
+
+	cfg, err := config.Load(opts.configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", opts.configPath, err)
+		return 1
+	}
+	// 通知渠道的必填字段由 notify 包检查
+	if err := notify.New().Configure(cfg.Notify); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: notify: %v\n", opts.configPath, err)
+		return 1
+	}
+	fmt.Printf("%s is valid\n", opts.configPath)
+	return 0
+}
+
+// showCommand 展示配置中的信息，目前支持 "show lines"
+func showCommand(opts globalOptions, args []string) int {
+	if len(args) == 0 || args[0] != "lines" {
+		fmt.Fprintln(os.Stderr, "usage: controller show lines")
+		return 2
+	}
+	fs := flag.NewFlagSet("show lines", flag.ExitOnError)
+	fs.Parse(args[1:])
+
+	// 只读取和校验线路相关的配置，不需要 DNS 服务商密钥
+	cfg, err := config.LoadLines(opts.configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", opts.configPath, err)
+		return 1
+	}
+
+	// 最近一次优选结果来自历史库；服务运行中时库被锁定，此时仅展示配置
+	var last map[string]store.Selection
+	if cfg.History.Enabled {
+		a := &AppContext{StateDir: opts.resolveStateDir(cfg)}
+		st, err := store.OpenReadOnly(a.historyPath(cfg), time.Second)
+		if errors.Is(err, os.ErrNotExist) {
+			// 尚未运行过，没有历史库
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "note: last selections unavailable: %v\n", err)
+		} else {
+			var keys []string
+			for _, g := range cfg.DNS.RecordGroups() {
+				for _, lc := range g.Lines {
+					keys = append(keys, models.LineKey(g.ID, lc.Operator, "v4"), models.LineKey(g.ID, lc.Operator, "v6"))
+				}
+			}
+			last, err = st.LatestSelections(keys)
+			st.Close()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "note: last selections unavailable: %v\n", err)
+			}
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "LINE\tNAME\tRECORD\tRECORDSET\tCAP\tMIN\tMAX LATENCY\tMIN SPEED\tMAX LOSS\tWEIGHTS (lat/spd/loss)\tFALLBACK\tLAST SELECTION")
+	for _, g := range cfg.DNS.RecordGroups() {
+		for _, lc := range g.Lines {
+			for _, ver := range []string{"v4", "v6"} {
+				key := models.LineKey(g.ID, lc.Operator, ver)
+				sc, th := lc.Effective(ver, cfg.Scoring, cfg.Thresholds)
+				recordset := lc.RecordsetID(ver)
+				if recordset == "" {
+					recordset = "-"
+				}
+				policy := lc.Fallback.Policy
+				if policy == "" {
+					policy = config.FallbackKeep
+				}
+				lastSel := "-"
+				if s, ok := last[key]; ok {
+					ips := make([]string, 0, len(s.Active))
+					for _, it := range s.Active {
+						ips = append(ips, it.IP)
+					}
+					lastSel = fmt.Sprintf("%s %s", s.At.Local().Format("2006-01-02 15:04"), strings.Join(ips, ","))
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\t%dms\t%.2fMbps\t%.2f%%\t%.2f/%.2f/%.2f\t%s\t%s\n",
+					key, cfg.OperatorName(lc.Operator), g.RecordName(), recordset, lc.CapFor(ver), lc.MinActiveOrDefault(),
+					th.MaxLatencyMs, th.MinDownloadMbps, th.MaxLossPct,
+					sc.LatencyWeight, sc.SpeedWeight, sc.LossWeight, policy, lastSel)
+			}
+		}
+	}
+	w.Flush()
+	return 0
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"controller/pkg/config"
+	"controller/pkg/logging"
+	"controller/pkg/metrics"
+	"controller/pkg/models"
+	"controller/pkg/report"
+	"controller/pkg/store"
+	"controller/pkg/updater"
+)
+
+// dnsMu 保证定时任务和后台监控不会同时写 DNS
+var dnsMu sync.Mutex
+
+// [修改] 返回成功更新的线路 (如 cu-v4) 及其结果
+// [新增] ctx 被取消时不再更新剩余线路 (单条线路的 API 调用不会被中断)
+// [新增] outcomes 记录每条线路的 DNS 结果 (report.DNSUpdated 等)，用于结果 Gist 的 manifest
+// [修改] 单条线路失败时记录错误并继续更新其余线路，返回的 err 汇总所有失败的线路
+func UpdateAll(ctx context.Context, logger *slog.Logger, selected map[string]models.LineResult, cfg *config.Config, st *store.Store) (updated map[string]models.LineResult, outcomes map[string]string, err error) {
+	updated = make(map[string]models.LineResult)
+	outcomes = make(map[string]string, len(selected))
+	for key := range selected {
+		outcomes[key] = report.DNSNotTried
+	}
+
+	var errs []error
+	dnsMu.Lock()
+	defer dnsMu.Unlock()
+	for key, lineResult := range selected {
+		if err := ctx.Err(); err != nil {
+			errs = append(errs, fmt.Errorf("DNS update aborted before line %s: %w", key, err))
+			return updated, outcomes, errors.Join(errs...)
+		}
+		outcome, err := updateLine(ctx, logging.ForLine(logger, key), key, lineResult, cfg, st)
+		outcomes[key] = outcome
+		if err != nil {
+			errs = append(errs, fmt.Errorf("line %s: %w", key, err))
+			continue
+		}
+		if outcome == report.DNSUpdated {
+			updated[key] = lineResult
+		}
+	}
+
+	if len(updated) == 0 && len(errs) == 0 {
+		logger.Info("no DNS records need updating in this run")
+	}
+	return updated, outcomes, errors.Join(errs...)
+}
+
+// [新增] updateLine 更新单条线路 (如 cu-v4) 的 DNS 记录，返回该线路的 DNS 结果 (report.DNSUpdated 等)
+// 供 UpdateAll 和后台监控的紧急切换共用，调用方需持有 dnsMu
+func updateLine(ctx context.Context, logger *slog.Logger, key string, lineResult models.LineResult, cfg *config.Config, st *store.Store) (string, error) {
+	if len(lineResult.Active) == 0 {
+		if lineResult.Fallback != "" {
+			logger.Warn("keeping existing DNS records due to fallback policy", "fallback", lineResult.Fallback)
+		}
+		return report.DNSKept, nil
+	}
+
+	target, err := resolveRecord(key, cfg)
+	if err != nil {
+		logger.Warn("no DNS record configured for line, skipping", "reason", err)
+		return report.DNSSkipped, nil
+	}
+	logger = logger.With(logging.KeyProvider, target.provider)
+	provider, err := updater.New(target.provider, cfg)
+	if err != nil {
+		return report.DNSFailed, err
+	}
+	if provider == nil {
+		logger.Info("DNS provider is disabled in config, skipping")
+		return report.DNSSkipped, nil
+	}
+
+	var ipsToUpdate []string
+	for _, item := range lineResult.Active {
+		ipsToUpdate = append(ipsToUpdate, item.IP)
+	}
+
+	logger = logger.With("line_name", target.friendlyName, "record_name", target.recordName, "recordset_id", target.recordsetID)
+	logger.Debug("updating DNS record", "ips", ipsToUpdate)
+
+	err = provider.UpdateRecordSet(ctx, updater.RecordSet{
+		ZoneID:      target.zoneID,
+		RecordsetID: target.recordsetID,
+		Name:        target.recordName,
+		Type:        target.recordType,
+		TTL:         target.ttl,
+		Line:        target.providerLine,
+		CheckLine:   target.checkLine,
+	}, ipsToUpdate)
+	recordDNSChange(logger, st, store.DNSChange{
+		At:          time.Now(),
+		Provider:    provider.Name(),
+		Line:        key,
+		ZoneID:      target.zoneID,
+		RecordsetID: target.recordsetID,
+		RecordName:  target.recordName,
+		RecordType:  target.recordType,
+		IPs:         ipsToUpdate,
+	}, err)
+	if err != nil {
+		metrics.DNSUpdates.Inc(provider.Name(), metrics.OutcomeFailure)
+		logger.Error("DNS update failed", "error", err)
+		return report.DNSFailed, err
+	}
+	metrics.DNSUpdates.Inc(provider.Name(), metrics.OutcomeSuccess)
+
+	logger.Info("DNS record updated", "ips", ipsToUpdate)
+	return report.DNSUpdated, nil
+}
+
+// recordDNSChange 将 DNS 更新结果写入历史库 (未启用历史库时忽略)
+func recordDNSChange(logger *slog.Logger, st *store.Store, c store.DNSChange, updateErr error) {
+	if st == nil {
+		return
+	}
+	if updateErr != nil {
+		c.Error = updateErr.Error()
+	}
+	if err := st.RecordDNSChange(c); err != nil {
+		logger.Warn("failed to record DNS change to history store", "error", err)
+	}
+}
+
+// recordTarget 是某条线路对应的 DNS 记录
+type recordTarget struct {
+	provider     string
+	zoneID       string
+	friendlyName string
+	providerLine string // 服务商中的线路 ID, 未配置时为空
+	checkLine    bool   // 显式配置了 operators 时更新前核对记录集所在线路
+	recordName   string
+	recordsetID  string
+	recordType   string
+	ttl          int
+}
+
+// resolveRecord 根据线路 key (如 cu-v4 或 cdn/cu-v4) 找到配置中对应的记录集
+func resolveRecord(key string, cfg *config.Config) (recordTarget, error) {
+	groupID, operatorCode, ipVersion := models.SplitLineKey(key)
+
+	group, ok := cfg.DNS.Group(groupID)
+	if !ok {
+		return recordTarget{}, fmt.Errorf("record group '%s' not found in config", groupID)
+	}
+	lineCfg, ok := group.Line(operatorCode)
+	if !ok {
+		return recordTarget{}, fmt.Errorf("operator '%s' not found in config", operatorCode)
+	}
+
+	op, _ := cfg.Operator(operatorCode)
+	t := recordTarget{
+		provider:     group.ProviderOrDefault(),
+		zoneID:       group.ZoneId,
+		friendlyName: cfg.OperatorName(operatorCode),
+		providerLine: op.ProviderLines[group.ProviderOrDefault()],
+		checkLine:    len(cfg.Operators) > 0,
+		recordName:   group.RecordName() + ".",
+		recordsetID:  lineCfg.RecordsetID(ipVersion),
+		recordType:   "A",
+		ttl:          group.TTL,
+	}
+	if ipVersion == "v6" {
+		t.recordType = "AAAA"
+	}
+	if t.recordsetID == "" {
+		return recordTarget{}, fmt.Errorf("%s recordset ID of operator '%s' is empty", t.recordType, operatorCode)
+	}
+	return t, nil
+}
+
+// printDNSPlan 输出 dry-run 模式下计划进行的 DNS 变更
+func printDNSPlan(w io.Writer, selected map[string]models.LineResult, cfg *config.Config) {
+	keys := make([]string, 0, len(selected))
+	for key := range selected {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fmt.Fprintln(w, "Planned DNS changes (dry-run, nothing was written):")
+	for _, key := range keys {
+		lr := selected[key]
+		target, err := resolveRecord(key, cfg)
+		switch {
+		case len(lr.Active) == 0:
+			fmt.Fprintf(w, "\n  %s: keep existing records (%s)\n", key, lr.Fallback)
+			continue
+		case err != nil:
+			fmt.Fprintf(w, "\n  %s: skipped (%v)\n", key, err)
+			continue
+		}
+		line := target.friendlyName
+		if target.providerLine != "" {
+			line += "/" + target.providerLine
+		}
+		fmt.Fprintf(w, "\n  %s [%s] %s %s %s (recordset %s)", key, line, target.provider, target.recordType, target.recordName, target.recordsetID)
+		if p, _ := updater.New(target.provider, cfg); p == nil {
+			fmt.Fprint(w, " (provider disabled, a real run would not update it)")
+		}
+		if lr.Fallback != "" {
+			fmt.Fprintf(w, " fallback: %s", lr.Fallback)
+		}
+		fmt.Fprintln(w)
+		for _, it := range lr.Active {
+			fmt.Fprintf(w, "    %-40s score %8.2f  latency %4dms  speed %7.2fMbps  colo %s\n", it.IP, it.Score, it.LatencyMs, it.DLMbps, it.Region)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"controller/pkg/config"
+	"controller/pkg/gist"
+	"controller/pkg/leader"
+	"controller/pkg/status"
+)
+
+// newElector 按配置创建选主器，未启用选主时返回 nil
+func newElector(cfg *config.Config) (*leader.Elector, error) {
+	lc := cfg.Leader
+	if !lc.Enabled {
+		return nil, nil
+	}
+	identity := lc.Identity
+	if identity == "" {
+		host, _ := os.Hostname()
+		identity = fmt.Sprintf("%s-%d", host, os.Getpid())
+	}
+
+	var lock leader.Lock
+	switch lc.Backend {
+	case config.LeaderBackendFile:
+		path := lc.File.Path
+		if path == "" {
+			path = filepath.Join(os.TempDir(), "cfst-controller.lock")
+		}
+		lock = leader.NewFileLock(path)
+	case config.LeaderBackendKubernetes:
+		k, err := leader.NewKubernetesLease(lc.Kubernetes.APIURL, lc.Kubernetes.Namespace, lc.Kubernetes.Name,
+			lc.Kubernetes.TokenFile, lc.Kubernetes.CAFile, identity, lc.LeaseDuration())
+		if err != nil {
+			return nil, err
+		}
+		lock = k
+	case config.LeaderBackendGist:
+		gistID, file := lc.Gist.GistID, lc.Gist.File
+		if gistID == "" {
+			gistID = cfg.Gist.ResultGistID
+		}
+		if file == "" {
+			file = "leader.json"
+		}
+		gc := gist.NewClient(cfg.Gist.Token, cfg.Gist.ProxyPrefix)
+		lock = leader.NewGistLease(gc, gistID, file, identity, lc.LeaseDuration())
+	default:
+		return nil, fmt.Errorf("unknown leader backend %q", lc.Backend)
+	}
+	return leader.NewElector(lock, identity, lc.RenewInterval()), nil
+}
+
+// isLeader 返回当前实例是否可以更新 DNS 和写结果 Gist，未启用选主时始终为 true
+func (a *AppContext) isLeader() bool {
+	return a.Elector == nil || a.Elector.IsLeader()
+}
+
+// errLostLeadership 是运行中途失去 leader 身份时取消运行的原因
+var errLostLeadership = errors.New("lost leadership during run")
+
+// leaderContext 返回在当前任期结束时被取消的 ctx，避免失去 leader 身份后继续写 DNS 和结果 Gist。
+// 未启用选主时原样返回 ctx
+func (a *AppContext) leaderContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if a.Elector == nil {
+		return ctx, func() {}
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	lost := a.Elector.Lost()
+	go func() {
+		select {
+		case <-lost:
+			cancel(errLostLeadership)
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() { cancel(nil) }
+}
+
+// role 返回当前选主角色，供状态查询
+func (a *AppContext) role() string {
+	switch {
+	case a.Elector == nil:
+		return ""
+	case a.Elector.IsLeader():
+		return status.RoleLeader
+	default:
+		return status.RoleFollower
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"controller/pkg/gist"
+	"controller/pkg/logging"
+	"controller/pkg/metrics"
+	"controller/pkg/state"
+	"controller/pkg/status"
+)
+
+// pendingUpload 是一次写入失败的结果 Gist 内容，保存在状态目录中等待下次运行重试
+type pendingUpload struct {
+	GistID     string            `json:"gist_id,omitempty"` // 为空表示需要新建结果 Gist
+	Files      map[string]string `json:"files"`
+	Deletions  []string          `json:"deletions,omitempty"`
+	SavedAt    time.Time         `json:"saved_at"`
+	Attempts   int               `json:"attempts"`
+	Error      string            `json:"error"`
+	Content    string            `json:"content_digest"`
+	Candidates string            `json:"candidates_digest"`
+}
+
+// pendingUploadPath 返回保存待重试的结果 Gist 内容的文件路径
+func (a *AppContext) pendingUploadPath() string {
+	return filepath.Join(a.StateDir, "pending_upload.json")
+}
+
+// loadPendingUpload 读取待重试的内容，没有时返回 nil
+func (a *AppContext) loadPendingUpload() (*pendingUpload, error) {
+	b, err := os.ReadFile(a.pendingUploadPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var p pendingUpload
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", a.pendingUploadPath(), err)
+	}
+	return &p, nil
+}
+
+// savePendingUpload 以原子方式写入待重试的内容，避免进程中途退出留下不完整的文件
+func (a *AppContext) savePendingUpload(p *pendingUpload) error {
+	b, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := state.WriteFile(a.pendingUploadPath(), b, 0600); err != nil {
+		return err
+	}
+	a.notePendingUpload(p)
+	return nil
+}
+
+// clearPendingUpload 在结果 Gist 写入成功后删除待重试的内容
+func (a *AppContext) clearPendingUpload(logger *slog.Logger) {
+	if err := os.Remove(a.pendingUploadPath()); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		logger.Warn("failed to remove pending upload file", "path", a.pendingUploadPath(), "error", err)
+	}
+	a.notePendingUpload(nil)
+}
+
+// notePendingUpload 将待重试的上传同步到运行状态和指标
+func (a *AppContext) notePendingUpload(p *pendingUpload) {
+	if p == nil {
+		a.Status.SetPendingUpload(nil)
+		metrics.ResultUploadPending.Set(0)
+		return
+	}
+	a.Status.SetPendingUpload(&status.PendingUpload{
+		GistID:    p.GistID,
+		Files:     len(p.Files),
+		Deletions: len(p.Deletions),
+		SavedAt:   p.SavedAt,
+		Attempts:  p.Attempts,
+		Error:     p.Error,
+	})
+	metrics.ResultUploadPending.Set(1)
+}
+
+// keepPendingUpload 保存写入失败的内容，prev 为之前未能重试成功的内容 (可为 nil)
+func (a *AppContext) keepPendingUpload(logger *slog.Logger, p *pendingUpload, prev *pendingUpload, uploadErr error) {
+	attempts := 1
+	if prev != nil {
+		attempts = prev.Attempts + 1
+	}
+	p.SavedAt, p.Attempts, p.Error = time.Now(), attempts, uploadErr.Error()
+	if err := a.savePendingUpload(p); err != nil {
+		logger.Error("failed to save pending upload, it will not be retried", "path", a.pendingUploadPath(), "error", err)
+		return
+	}
+	logger.Warn("result gist upload saved, will retry on next run", "path", a.pendingUploadPath(), "attempts", p.Attempts)
+}
+
+// retryPendingUpload 重试上一次失败的结果 Gist 写入，返回当前的结果 Gist ID 以及仍未成功的内容 (没有时为 nil)。
+// 成功后把该内容记为最近一次写入，本次运行内容相同时不会重复写入。
+func (a *AppContext) retryPendingUpload(ctx context.Context, logger *slog.Logger, gc *gist.Client, resultGistID string) (string, *pendingUpload) {
+	p, err := a.loadPendingUpload()
+	if err != nil {
+		logger.Warn("failed to load pending upload, discarding it", "error", err)
+		a.clearPendingUpload(logger)
+		return resultGistID, nil
+	}
+	if p == nil {
+		return resultGistID, nil
+	}
+	a.notePendingUpload(p)
+
+	gistID := resultGistID
+	if gistID == "" {
+		gistID = p.GistID
+	}
+	logger = logger.With(logging.KeyGistID, gistID)
+	logger.Info("retrying failed result gist upload", "saved_at", p.SavedAt, "attempts", p.Attempts)
+	start := time.Now()
+	outGistID, err := gc.CreateOrUpdateResultGist(ctx, gistID, p.Files, p.Deletions)
+	if err != nil {
+		metrics.ObservePhase(metrics.PhaseUpload, start, metrics.OutcomeFailure)
+		logger.Warn("retry of result gist upload failed", "error", err)
+		a.keepPendingUpload(logger, p, p, err)
+		return resultGistID, p
+	}
+	metrics.ObservePhase(metrics.PhaseUpload, start, metrics.OutcomeSuccess)
+	a.clearPendingUpload(logger)
+	if gistID == "" && outGistID != "" {
+		gistID = outGistID
+		a.saveResultGistID(logger, outGistID)
+	}
+	a.setLastPublish(logger, publishDigest{gistID: gistID, content: p.Content, candidates: p.Candidates})
+	logger.Info("pending result gist upload succeeded")
+	return gistID, nil
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"controller/pkg/config"
+	"controller/pkg/gist"
+	"controller/pkg/models"
+	"controller/pkg/render"
+	"controller/pkg/report"
+)
+
+// deviceCounts 统计每台设备贡献的结果数量
+func deviceCounts(gistID string, drs []models.DeviceResult) []report.Device {
+	counts := make(map[string]int)
+	for _, d := range drs {
+		counts[d.Device]++
+	}
+	devices := make([]report.Device, 0, len(counts))
+	for name, n := range counts {
+		devices = append(devices, report.Device{GistID: gistID, Device: name, Results: n})
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].Device < devices[j].Device })
+	return devices
+}
+
+// buildReport 汇总本次运行的设备、线路参数和 DNS 结果，用于生成结果 Gist 的附加文件
+func buildReport(cfg *config.Config, runID string, at time.Time, devices []report.Device,
+	selected map[string]models.LineResult, outcomes map[string]string) report.Run {
+	run := report.Run{ID: runID, At: at, Devices: devices}
+	for key, lr := range selected {
+		l := report.Line{Key: key, Name: cfg.OperatorName(lr.Operator), Result: lr, DNS: outcomes[key]}
+		if group, ok := cfg.DNS.Group(lr.Group); ok {
+			l.Record = group.RecordName()
+			if lc, ok := group.Line(lr.Operator); ok {
+				sc, th := lc.Effective(lr.IPVersion, cfg.Scoring, cfg.Thresholds)
+				l.Thresholds = report.Thresholds{MaxLatencyMs: th.MaxLatencyMs, MinDownloadMbps: th.MinDownloadMbps, MaxLossPct: th.MaxLossPct}
+				l.Weights = report.Weights{Latency: sc.LatencyWeight, Speed: sc.SpeedWeight, Loss: sc.LossWeight}
+			}
+		}
+		run.Lines = append(run.Lines, l)
+	}
+	sort.Slice(run.Lines, func(i, j int) bool { return run.Lines[i].Key < run.Lines[j].Key })
+	return run
+}
+
+// contentFiles 生成结果 Gist 中由本次运行决定的文件: 各线路的 JSON、publish.outputs 中的 IP 池文件、
+// summary.md 和 manifest.json (不含需要读取旧内容的 history.jsonl)
+func contentFiles(logger *slog.Logger, cfg *config.Config, selected map[string]models.LineResult, run report.Run) map[string]string {
+	files := models.BuildResultGistFiles(selected, run.At)
+	if len(cfg.Publish.Outputs) > 0 {
+		outputs, err := render.Files(cfg.Publish.Outputs, selected)
+		if err != nil {
+			logger.Warn("failed to render outputs, skipping", "error", err)
+		}
+		maps.Copy(files, outputs)
+	}
+
+	if cfg.Publish.Summary {
+		files[report.SummaryFile] = report.BuildSummary(run)
+	}
+	if cfg.Publish.Manifest {
+		addManifest(logger, files, run, nil)
+	}
+	return files
+}
+
+// addManifest 生成 manifest.json，其中 files 列出 files 和 kept 中的文件及 manifest.json 自身，
+// 下次运行据此判断结果 Gist 中哪些旧文件由本程序写入
+func addManifest(logger *slog.Logger, files map[string]string, run report.Run, kept []string) {
+	names := append(slices.Collect(maps.Keys(files)), kept...)
+	names = append(names, report.ManifestFile)
+	slices.Sort(names)
+	run.Files = slices.Compact(names)
+	manifest, err := report.BuildManifest(run)
+	if err != nil {
+		logger.Warn("failed to build manifest, skipping", "error", err)
+		delete(files, report.ManifestFile)
+		return
+	}
+	files[report.ManifestFile] = manifest
+}
+
+// resultFiles 生成要写入结果 Gist 的全部文件，启用 history_size 时追加 history.jsonl；
+// [新增] 按 publish.prune 处理本次不再生成的旧文件，返回需要删除的文件名
+func resultFiles(ctx context.Context, logger *slog.Logger, gc *gist.Client, cfg *config.Config, resultGistID string,
+	selected map[string]models.LineResult, run report.Run) (files map[string]string, deletions []string) {
+	files = contentFiles(logger, cfg, selected, run)
+	var kept []string
+	// manifest.json 需要列出最终写入的全部文件，在追加历史和清理之后重新生成
+	defer func() {
+		if cfg.Publish.Manifest {
+			addManifest(logger, files, run, kept)
+		}
+	}()
+	needExisting := cfg.Publish.HistorySize > 0 || cfg.Publish.PruneOrDefault() != config.PruneOff
+	if resultGistID == "" || !needExisting {
+		if cfg.Publish.HistorySize > 0 {
+			appendHistory(logger, cfg, files, "", run)
+		}
+		return files, nil
+	}
+
+	existing, err := gc.Files(ctx, resultGistID)
+	if err != nil {
+		// 读取失败时不更新历史也不清理, 避免丢失已有内容
+		logger.Warn("failed to read existing result gist, skipping history and pruning", "error", err)
+		return files, nil
+	}
+	if cfg.Publish.HistorySize > 0 {
+		appendHistory(logger, cfg, files, existing[report.HistoryFile], run)
+	}
+	if cfg.Publish.PruneOrDefault() != config.PruneOff {
+		owned, ok := report.ManifestFiles(existing[report.ManifestFile])
+		if ok {
+			deletions, kept = pruneStale(logger, cfg, existing, files, owned, run.At)
+		} else {
+			logger.Info("no manifest from a previous run in result gist, skipping pruning")
+		}
+	}
+	return files, deletions
+}
+
+func appendHistory(logger *slog.Logger, cfg *config.Config, files map[string]string, existing string, run report.Run) {
+	history, err := report.AppendHistory(existing, run, cfg.Publish.HistorySize)
+	if err != nil {
+		logger.Warn("failed to build history, skipping", "error", err)
+		return
+	}
+	files[report.HistoryFile] = history
+}
+
+// pruneStale 找出结果 Gist 中由本程序写入 (列在上一次 manifest.json 的 owned 中)、但本次不再生成的文件:
+// expire 模式下线路文件改为空结果 (已过期的不再重复写入，以 kept 返回以便仍记入 manifest)，
+// 其余文件返回给调用方删除。手动添加的文件和选主租约文件不在 owned 中，不会被清理
+func pruneStale(logger *slog.Logger, cfg *config.Config, existing, files map[string]string, owned []string, now time.Time) (deletions, kept []string) {
+	for _, name := range slices.Sorted(maps.Keys(existing)) {
+		if _, ok := files[name]; ok || !slices.Contains(owned, name) {
+			continue
+		}
+		if cfg.Publish.PruneOrDefault() == config.PruneExpire && models.IsResultFileName(name) {
+			var old models.GistFileContent
+			if json.Unmarshal([]byte(existing[name]), &old) == nil && old.Expired {
+				kept = append(kept, name)
+				continue
+			}
+			logger.Info("marking stale result file as expired", "file", name)
+			files[name] = models.ExpiredResultFile(now)
+			continue
+		}
+		logger.Info("deleting stale result file", "file", name)
+		deletions = append(deletions, name)
+	}
+	return deletions, kept
+}
+
+// publishDigest 是上一次写入结果 Gist 的内容摘要
+type publishDigest struct {
+	gistID     string
+	content    string // 去掉时间戳和运行 ID 后全部文件的摘要
+	candidates string // 各线路候选 IP 集合的摘要
+}
+
+// digestFor 计算本次运行要写入的内容摘要。
+// 时间戳和运行 ID 被置空，因此只有实际内容变化才会改变 content
+func digestFor(cfg *config.Config, gistID string, selected map[string]models.LineResult, run report.Run) publishDigest {
+	run.ID, run.At = "", time.Time{}
+	files := contentFiles(slog.New(slog.DiscardHandler), cfg, selected, run)
+	names := slices.Sorted(maps.Keys(files))
+	h := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(h, "%s\x00%s\x00", name, files[name])
+	}
+	d := publishDigest{gistID: gistID, content: hex.EncodeToString(h.Sum(nil))}
+
+	h = sha256.New()
+	for _, key := range slices.Sorted(maps.Keys(selected)) {
+		ips := make([]string, 0, len(selected[key].Candidates))
+		for _, it := range selected[key].Candidates {
+			ips = append(ips, it.IP)
+		}
+		sort.Strings(ips)
+		fmt.Fprintf(h, "%s\x00%s\x00", key, strings.Join(ips, ","))
+	}
+	d.candidates = hex.EncodeToString(h.Sum(nil))
+	return d
+}
+
+// shouldPublish 按 publish.mode 和上一次写入的摘要决定是否写入结果 Gist，不写入时返回原因
+func (a *AppContext) shouldPublish(cfg *config.Config, d publishDigest) (bool, string) {
+	last := a.lastPublish
+	switch cfg.Publish.ModeOrDefault() {
+	case config.PublishNever:
+		return false, "publish.mode is never"
+	case config.PublishOnChange:
+		if d.gistID != "" && d.gistID == last.gistID && d.candidates == last.candidates {
+			return false, "candidate IPs unchanged"
+		}
+	}
+	if d.gistID != "" && d.gistID == last.gistID && d.content == last.content {
+		return false, "content unchanged"
+	}
+	return true, ""
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"controller/pkg/api"
+	"controller/pkg/config"
+	"controller/pkg/logging"
+	"controller/pkg/metrics"
+	"controller/pkg/models"
+	"controller/pkg/monitor"
+	"controller/pkg/notify"
+	"controller/pkg/probe"
+
+	"github.com/robfig/cron/v3"
+)
+
+// serve 启动定时任务循环 (以及可选的后台监控和 HTTP API)，直到收到退出信号
+func serve(opts globalOptions, args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	fs.Parse(args)
+
+	// [修改] 首次加载配置仅用于获取 cron 表达式和启动前检查
+	appCtx, initialCfg, err := newAppContext(opts, false)
+	if err != nil {
+		fatal("failed to start", "error", err)
+	}
+	defer appCtx.Close()
+	st := appCtx.Store
+	slog.Info("multi-net controller starting", "config", opts.configPath, "state_dir", appCtx.StateDir)
+
+	if initialCfg.Cron.Spec == "" {
+		fatal("'cron.spec' is not set in config.yml, please add a valid cron expression to proceed")
+	}
+
+	// [新增] 选主: 首次获取锁是同步的，首次运行即可知道自己是否为 leader
+	elector, err := newElector(initialCfg)
+	if err != nil {
+		fatal("failed to set up leader election", "error", err)
+	}
+	if elector != nil {
+		appCtx.Elector = elector
+		appCtx.Status.SetRole(appCtx.role)
+		elector.Start(context.Background())
+		slog.Info("leader election enabled", "backend", initialCfg.Leader.Backend,
+			"identity", elector.Identity(), "leader", elector.IsLeader())
+	}
+
+	// [新增] 后台监控: 在两次定时任务之间探测已发布的 IP，连续失败时对该线路做紧急切换
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	if initialCfg.Monitor.Enabled {
+		mon := monitor.New(func(key string, lr models.LineResult) error {
+			cfg := appCtx.config()
+			if !appCtx.isLeader() {
+				return fmt.Errorf("not the leader")
+			}
+			dnsMu.Lock()
+			defer dnsMu.Unlock()
+			logger := logging.ForLine(slog.With(logging.KeyPhase, "monitor"), key)
+			if _, err := updateLine(bgCtx, logger, key, lr, cfg, st); err != nil {
+				appCtx.Notifier.Notify(notify.FailureEvent(notify.SeverityError, "failover", key, err))
+				return err
+			}
+			appCtx.notePublished(cfg, key, lr)
+			return nil
+		}, func(key string, err error) {
+			appCtx.Notifier.Notify(notify.FailureEvent(notify.SeverityWarning, "failover", key, err))
+		})
+		appCtx.Monitor = mon
+		// 重启后先监控状态文件中记录的已发布 IP，不必等到下一次 DNS 更新
+		mon.SetPublished(appCtx.publishedLines())
+		go mon.Run(bgCtx, func() monitor.Settings {
+			cfg := appCtx.config()
+			return monitor.Settings{
+				ProberFor: func(key string) *probe.Prober {
+					groupID, _, _ := models.SplitLineKey(key)
+					group, _ := cfg.DNS.Group(groupID)
+					return probe.New(cfg.Probe, group.RecordName())
+				},
+				LineFor: func(key string) (config.Line, bool) {
+					groupID, operator, _ := models.SplitLineKey(key)
+					group, _ := cfg.DNS.Group(groupID)
+					return group.Line(operator)
+				},
+				Interval:  cfg.Monitor.Interval(),
+				Threshold: cfg.Monitor.FailureThresholdOrDefault(),
+				Standby:   !appCtx.isLeader(),
+			}
+		})
+		slog.Info("published IP monitor started", "interval", initialCfg.Monitor.Interval().String(),
+			"failure_threshold", initialCfg.Monitor.FailureThresholdOrDefault())
+	}
+
+	// 创建 Cron 调度器
+	c := cron.New()
+
+	// [核心修改] 将所有逻辑（包括配置重载）放入 cron 执行的函数中
+	entryID, err := c.AddFunc(initialCfg.Cron.Spec, appCtx.scheduledRun)
+
+	if err != nil {
+		fatal("invalid cron spec", "spec", initialCfg.Cron.Spec, "error", err)
+	}
+	// [新增] 热重载时 cron.spec 变化则替换定时任务
+	var entryMu sync.Mutex
+	appCtx.reschedule = func(spec string) error {
+		id, err := c.AddFunc(spec, appCtx.scheduledRun)
+		if err != nil {
+			return err
+		}
+		entryMu.Lock()
+		old := entryID
+		entryID = id
+		entryMu.Unlock()
+		c.Remove(old)
+		return nil
+	}
+
+	// 启动定时器，并立即触发一次任务
+	c.Start()
+	slog.Info("cron scheduler started, triggering initial run", "spec", initialCfg.Cron.Spec)
+
+	// [新增] 可选的 HTTP API，监听地址和 Token 修改后需重启生效
+	var apiServer *api.Server
+	if initialCfg.API.Enabled {
+		if initialCfg.API.Token == "" {
+			fatal("'api.token' must be set when the HTTP API is enabled")
+		}
+		apiServer = api.NewServer(initialCfg.API.Listen, initialCfg.API.Token, appCtx.Status,
+			// 同步获取运行权，使返回结果与实际是否运行 (或排队) 一致
+			func() (queued, ok bool) {
+				switch appCtx.beginRun() {
+				case runStarted:
+					go appCtx.runLoop()
+					return false, true
+				case runQueued:
+					return true, true
+				}
+				return false, false
+			},
+			func() time.Time {
+				entryMu.Lock()
+				defer entryMu.Unlock()
+				return c.Entry(entryID).Next
+			},
+		)
+		if initialCfg.API.Metrics {
+			apiServer.Handle("GET /metrics", metrics.Default.Handler(), initialCfg.API.MetricsAuth)
+		}
+		apiServer.Start()
+	}
+
+	// [新增] 监视配置文件变化，SIGHUP 同样触发重载
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	if initialCfg.Reload.Watch {
+		go config.Watch(bgCtx, appCtx.ConfigPath, initialCfg.Reload.Interval(), func() {
+			appCtx.reloadConfig("file changed")
+		})
+		slog.Info("watching config file for changes", "path", appCtx.ConfigPath, "interval", initialCfg.Reload.Interval().String())
+	}
+
+	// 立即执行一次任务 (与定时任务共用运行保护，不会与首次定时触发重叠)
+	go appCtx.scheduledRun()
+
+	// 优雅地关闭
+	for s := range sig {
+		if s != syscall.SIGHUP {
+			break
+		}
+		go appCtx.reloadConfig("SIGHUP")
+	}
+	slog.Info("shutting down scheduler")
+	// [新增] 先停止调度，再等待 (或超时后取消) 进行中的运行
+	cronStopped := c.Stop()
+	appCtx.Shutdown(appCtx.config().Run.ShutdownGrace())
+	<-cronStopped.Done()
+	stopBackground()
+	if elector != nil {
+		elector.Stop()
+	}
+	if apiServer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := apiServer.Shutdown(ctx); err != nil {
+			slog.Warn("failed to shut down HTTP API", "error", err)
+		}
+		cancel()
+	}
+	slog.Info("shutdown complete")
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+
+	"controller/pkg/config"
+	"controller/pkg/gist"
+	"controller/pkg/logging"
+	"controller/pkg/models"
+	"controller/pkg/state"
+)
+
+// restoreState 用状态文件恢复各线路最近发布的 IP 和结果 Gist 的写入摘要，
+// 重启后第一次运行也能正确判断 IP 是否变化、结果 Gist 是否需要写入
+func (a *AppContext) restoreState() {
+	st := a.State.Get()
+	for key, l := range st.Lines {
+		groupID, operator, ipVersion := models.SplitLineKey(key)
+		a.published[key] = models.LineResult{Group: groupID, Operator: operator, IPVersion: ipVersion, Active: l.Active}
+	}
+	p := st.LastPublish
+	a.lastPublish = publishDigest{gistID: p.GistID, content: p.Content, candidates: p.Candidates}
+	slog.Info("state loaded", "path", a.State.Path(), logging.KeyGistID, st.ResultGistID,
+		"lines", len(st.Lines), "last_success", st.LastSuccess)
+}
+
+// updateState 修改状态文件，写入失败只记录日志
+func (a *AppContext) updateState(logger *slog.Logger, fn func(*state.State)) {
+	if err := a.State.Update(fn); err != nil {
+		logger.Warn("failed to save state", "path", a.State.Path(), "error", err)
+	}
+}
+
+// saveLine 记录线路最近一次发布到 DNS 的 IP 及对应的服务商记录
+func (a *AppContext) saveLine(cfg *config.Config, key string, lr models.LineResult) {
+	l := state.Line{Active: lr.Active, PublishedAt: time.Now()}
+	if t, err := resolveRecord(key, cfg); err == nil {
+		l.Provider, l.ZoneID, l.RecordsetID, l.RecordName, l.RecordType = t.provider, t.zoneID, t.recordsetID, t.recordName, t.recordType
+	}
+	a.updateState(logging.ForLine(slog.Default(), key), func(st *state.State) {
+		if st.Lines == nil {
+			st.Lines = make(map[string]state.Line)
+		}
+		st.Lines[key] = l
+	})
+}
+
+// saveResultGistID 将新建的结果 Gist ID 保存到状态文件
+func (a *AppContext) saveResultGistID(logger *slog.Logger, gistID string) {
+	logger.Warn("new result gist created, it is recommended to add this ID to config.yml",
+		logging.KeyGistID, gistID, "saved_to", a.State.Path())
+	a.updateState(logger, func(st *state.State) { st.ResultGistID = gistID })
+}
+
+// setLastPublish 记录最近一次写入结果 Gist 的摘要
+func (a *AppContext) setLastPublish(logger *slog.Logger, d publishDigest) {
+	a.lastPublish = d
+	a.updateState(logger, func(st *state.State) {
+		st.LastPublish = state.Publish{GistID: d.gistID, Content: d.content, Candidates: d.candidates, At: time.Now()}
+	})
+}
+
+// saveETags 保存设备 Gist 元数据缓存，供下次运行发起条件请求
+func (a *AppContext) saveETags(logger *slog.Logger, cache map[string]gist.GistCache) {
+	a.updateState(logger, func(st *state.State) { st.ETags = cache })
+}
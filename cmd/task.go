@@ -0,0 +1,397 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"time"
+
+	"controller/pkg/aggregator"
+	"controller/pkg/config"
+	"controller/pkg/gist"
+	"controller/pkg/logging"
+	"controller/pkg/metrics"
+	"controller/pkg/models"
+	"controller/pkg/notify"
+	"controller/pkg/probe"
+	"controller/pkg/report"
+	"controller/pkg/selector"
+	"controller/pkg/state"
+	"controller/pkg/status"
+	"controller/pkg/store"
+)
+
+// [修改] runTask 现在接收配置和 Gist ID 作为参数，配置不再依赖外部上下文; 新建的结果 Gist ID 保存在状态文件中
+// [新增] 历史库、后台监控和运行状态取自 AppContext，均可为 nil
+// [修改] 同时返回本次运行的结果，供 "run --once" 决定退出码
+// [新增] ctx 超时或被取消时各阶段尽快退出，本次运行记为失败
+// [修改] 结果 Gist 写入失败不再退出进程: 内容保存到状态目录，下次运行时重试
+func (a *AppContext) runTask(ctx context.Context, cfg *config.Config, resultGistID string) (result status.Run) {
+	runID := logging.NewRunID()
+	runLogger := slog.With(logging.KeyRunID, runID)
+	runLogger.Info("task started")
+
+	gc := gist.NewClient(cfg.Gist.Token, cfg.Gist.ProxyPrefix).WithCache(a.State.Get().ETags)
+	runAt := time.Now()
+	st, mon := a.Store, a.Monitor
+	if a.DryRun {
+		// dry-run 只读取历史库 (EWMA 打分)，不写入也不清理
+		runLogger.Info("dry-run mode: no DNS provider calls, no result gist or history writes")
+	} else if st != nil {
+		defer pruneHistory(runLogger, st, cfg.History.RetentionDays)
+	}
+
+	run := status.Run{StartedAt: runAt, Outcome: status.OutcomeSuccess}
+	a.Status.StartRun()
+	defer func() {
+		run.FinishedAt = time.Now()
+		a.Status.FinishRun(run)
+		metrics.RunsTotal.Inc(run.Outcome)
+		metrics.RunDuration.Set(run.FinishedAt.Sub(runAt).Seconds())
+		metrics.LastRunTimestamp.Set(float64(run.FinishedAt.Unix()))
+		if remaining, ok := gc.RateLimitRemaining(); ok {
+			metrics.GistRateLimitRemaining.Set(float64(remaining))
+		}
+		if run.Outcome == status.OutcomeSuccess && !a.DryRun {
+			a.updateState(runLogger, func(s *state.State) { s.LastSuccess = run.FinishedAt })
+		}
+		runLogger.Info("task finished", "outcome", run.Outcome, "updated_lines", run.UpdatedLines,
+			"duration", run.FinishedAt.Sub(runAt).Round(time.Millisecond).String())
+		result = run
+	}()
+
+	// [新增] 运行超时或被取消时，以失败结束本次运行
+	aborted := func(logger *slog.Logger, phase string, phaseStart time.Time) bool {
+		err := ctx.Err()
+		if err == nil {
+			return false
+		}
+		logger.Error("run aborted", "error", err)
+		run.Outcome, run.Error = status.OutcomeFailed, fmt.Sprintf("aborted during %s: %v", phase, err)
+		metrics.ObservePhase(phase, phaseStart, metrics.OutcomeFailure)
+		a.Notifier.Notify(notify.FailureEvent(notify.SeverityError, phase, "", err))
+		return true
+	}
+
+	// [新增] 先重试上一次写入失败的结果 Gist
+	var pending *pendingUpload
+	if !a.DryRun && a.isLeader() {
+		retryLogger := runLogger.With(logging.KeyPhase, metrics.PhaseUpload)
+		gc.WithLogger(retryLogger)
+		resultGistID, pending = a.retryPendingUpload(ctx, retryLogger, gc, resultGistID)
+	}
+
+	logger := runLogger.With(logging.KeyPhase, metrics.PhaseFetch)
+	gc.WithLogger(logger)
+	phaseStart := time.Now()
+	var allResults []models.DeviceResult
+	var devices []report.Device
+	metrics.ResultsIngested.Reset()
+	for _, gid := range cfg.Gist.DeviceGists {
+		if ctx.Err() != nil {
+			break
+		}
+		dg, err := gc.FetchDeviceResults(ctx, gid, cfg.Gist.GistUpdateCheckMinutes, cfg.OperatorCodes())
+		drs := dg.Results
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			logger.Warn("could not process device gist", logging.KeyGistID, gid, "error", err)
+			a.Notifier.Notify(notify.FailureEvent(notify.SeverityWarning, metrics.PhaseFetch, gid, err))
+			continue
+		}
+		allResults = append(allResults, drs...)
+		devices = append(devices, deviceCounts(gid, drs)...)
+		for _, d := range drs {
+			metrics.ResultsIngested.Add(1, d.Device, d.Operator+"-"+d.IPVersion)
+		}
+		a.Status.SeenDevices(gid, runAt, drs)
+		// [修改] 同一批测速结果只写入一次历史库，时间取 Gist 的更新时间，避免在 EWMA 中被重复计权
+		if st != nil && !a.DryRun && len(drs) > 0 && dg.Changed {
+			if err := st.RecordMeasurements(dg.UpdatedAt, gid, drs); err != nil {
+				logger.Warn("failed to record measurements to history store", logging.KeyGistID, gid, "error", err)
+			}
+		}
+	}
+
+	if !a.DryRun {
+		a.saveETags(logger, gc.Cache())
+	}
+	if aborted(logger, metrics.PhaseFetch, phaseStart) {
+		return run
+	}
+	if len(allResults) == 0 {
+		logger.Info("no recently updated gists or valid results found, task ends")
+		run.Outcome = status.OutcomeNoResults
+		metrics.ObservePhase(metrics.PhaseFetch, phaseStart, metrics.OutcomeSkipped)
+		return run
+	}
+	metrics.ObservePhase(metrics.PhaseFetch, phaseStart, metrics.OutcomeSuccess)
+	logger.Info("fetched device results", "results", len(allResults))
+
+	logger = runLogger.With(logging.KeyPhase, metrics.PhaseAggregate)
+	phaseStart = time.Now()
+	ag := aggregator.Aggregate(allResults)
+	metrics.ObservePhase(metrics.PhaseAggregate, phaseStart, metrics.OutcomeSuccess)
+	logger.Info("aggregated results", "groups", len(ag))
+
+	logger = runLogger.With(logging.KeyPhase, metrics.PhaseSelect)
+	phaseStart = time.Now()
+	history := loadScoringHistory(logger, cfg, st)
+	selected := selector.SelectGroups(ag, cfg.DNS.RecordGroups(), cfg.Scoring, cfg.Thresholds, history)
+	metrics.ObservePhase(metrics.PhaseSelect, phaseStart, metrics.OutcomeSuccess)
+	for key, lr := range selected {
+		lineLogger := logging.ForLine(logger, key)
+		if lr.Fallback != "" {
+			lineLogger.Warn("line has too few qualifying IPs, fallback applied", "fallback", lr.Fallback, "active", len(lr.Active))
+		}
+		lineLogger.Debug("line selected", "active", len(lr.Active), "candidates", len(lr.Candidates))
+	}
+	logger.Info("selected top IPs", "lines", len(selected))
+	if st != nil && !a.DryRun {
+		if err := st.RecordSelections(runAt, selected); err != nil {
+			logger.Warn("failed to record selections to history store", "error", err)
+		}
+	}
+
+	if cfg.Probe.Enabled {
+		logger = runLogger.With(logging.KeyPhase, metrics.PhaseProbe)
+		phaseStart = time.Now()
+		var failures map[string][]probe.Result
+		selected, failures = probeGroups(ctx, cfg, selected)
+		// [新增] 探测剔除 IP 后不足 min_active 的线路再次兜底，兜底选出的 IP 同样需要通过探测
+		excluded := make(map[string]map[string]bool)
+		pending := markFailed(excluded, failures)
+		for round := 0; round < probeRefillRounds && len(pending) > 0 && ctx.Err() == nil; round++ {
+			refilled := selector.RefillGroups(ag, cfg.DNS.RecordGroups(), cfg.Scoring, cfg.Thresholds, history, selected, pending)
+			if len(refilled) == 0 {
+				pending = nil
+				break
+			}
+			v, f := probeGroups(ctx, cfg, refilled)
+			maps.Copy(selected, v)
+			for key, results := range f {
+				failures[key] = append(failures[key], results...)
+			}
+			pending = markFailed(excluded, f)
+		}
+		selector.KeepBelowMinActive(cfg.DNS.RecordGroups(), selected, pending)
+		if aborted(logger, metrics.PhaseProbe, phaseStart) {
+			return run
+		}
+		for key, results := range failures {
+			lineLogger := logging.ForLine(logger, key)
+			for _, r := range results {
+				lineLogger.Warn("IP failed probe, dropped", "ip", r.IP, "error", r.Err)
+			}
+			lineLogger.Info("active IPs after probing", "active", len(selected[key].Active), "fallback", selected[key].Fallback)
+		}
+		metrics.ObservePhase(metrics.PhaseProbe, phaseStart, metrics.OutcomeSuccess)
+	}
+	a.Status.SetLines(selected)
+	observeLines(selected)
+
+	logger = runLogger.With(logging.KeyPhase, metrics.PhaseUpdate)
+	if a.DryRun {
+		printDNSPlan(os.Stdout, selected, cfg)
+		return run
+	}
+	// [新增] 只有 leader 更新 DNS 和结果 Gist，其余副本只保留优选结果供查询
+	if !a.isLeader() {
+		logger.Info("not the leader, skipping DNS update and result gist upload")
+		run.Outcome = status.OutcomeStandby
+		metrics.PhaseOutcomes.Inc(metrics.PhaseUpdate, metrics.OutcomeSkipped)
+		metrics.PhaseOutcomes.Inc(metrics.PhaseUpload, metrics.OutcomeSkipped)
+		return run
+	}
+	// [新增] 运行中途失去 leader 身份时取消剩余的 DNS 更新和结果 Gist 写入
+	ctx, stopLeaderWatch := a.leaderContext(ctx)
+	defer stopLeaderWatch()
+	phaseStart = time.Now()
+	updated, outcomes, err := UpdateAll(ctx, logger, selected, cfg, st)
+	if mon != nil {
+		mon.SetPublished(updated)
+	}
+	for key, lr := range updated {
+		a.notePublished(cfg, key, lr)
+	}
+	run.UpdatedLines = len(updated)
+	if err != nil {
+		// [修改] DNS 失败记入本次运行和各线路的 DNS 结果，结果 Gist 照常写入 (运行被取消时除外)
+		logger.Error("a critical error occurred during DNS update", "error", err)
+		run.Outcome, run.Error = status.OutcomeFailed, err.Error()
+		metrics.ObservePhase(metrics.PhaseUpdate, phaseStart, metrics.OutcomeFailure)
+		a.Notifier.Notify(notify.FailureEvent(notify.SeverityError, metrics.PhaseUpdate, "", err))
+		if ctx.Err() != nil {
+			if cause := context.Cause(ctx); errors.Is(cause, errLostLeadership) {
+				run.Error = joinRunError(run.Error, cause.Error())
+			}
+			return run
+		}
+	} else {
+		metrics.ObservePhase(metrics.PhaseUpdate, phaseStart, metrics.OutcomeSuccess)
+	}
+
+	logger = runLogger.With(logging.KeyPhase, metrics.PhaseUpload, logging.KeyGistID, resultGistID)
+	gc.WithLogger(logger)
+	// [新增] DNS 更新期间可能已失去 leader 身份，此时结果 Gist 由新的 leader 写入
+	if !a.isLeader() {
+		logger.Warn("no longer the leader, skipping result gist upload")
+		if run.Outcome == status.OutcomeSuccess {
+			run.Outcome = status.OutcomeStandby
+		}
+		metrics.PhaseOutcomes.Inc(metrics.PhaseUpload, metrics.OutcomeSkipped)
+		return run
+	}
+	rep := buildReport(cfg, runID, runAt, devices, selected, outcomes)
+	digest := digestFor(cfg, resultGistID, selected, rep)
+	// [修改] 结果 Gist 的写入与 DNS 是否更新无关，由 publish.mode 和内容摘要决定
+	if ok, reason := a.shouldPublish(cfg, digest); ok {
+		phaseStart = time.Now()
+		filesToUpload, deletions := resultFiles(ctx, logger, gc, cfg, resultGistID, selected, rep)
+		outGistID, err := gc.CreateOrUpdateResultGist(ctx, resultGistID, filesToUpload, deletions)
+		if err != nil {
+			logger.Error("failed to push result gist", "error", err)
+			run.Outcome, run.Error = status.OutcomeFailed, joinRunError(run.Error, fmt.Sprintf("failed to push result gist: %v", err))
+			metrics.ObservePhase(metrics.PhaseUpload, phaseStart, metrics.OutcomeFailure)
+			a.Notifier.Notify(notify.FailureEvent(notify.SeverityError, metrics.PhaseUpload, resultGistID, err))
+			// 本次内容比之前未成功的内容更新，直接替换
+			a.keepPendingUpload(logger, &pendingUpload{
+				GistID:     resultGistID,
+				Files:      filesToUpload,
+				Deletions:  deletions,
+				Content:    digest.content,
+				Candidates: digest.candidates,
+			}, pending, err)
+			return run
+		}
+		metrics.ObservePhase(metrics.PhaseUpload, phaseStart, metrics.OutcomeSuccess)
+		if pending != nil {
+			a.clearPendingUpload(logger)
+		}
+
+		if resultGistID == "" && outGistID != "" {
+			resultGistID = outGistID
+			a.saveResultGistID(logger, outGistID)
+		}
+		digest.gistID = resultGistID
+		a.setLastPublish(logger, digest)
+	} else {
+		logger.Info("result gist was not updated", "reason", reason)
+		metrics.PhaseOutcomes.Inc(metrics.PhaseUpload, metrics.OutcomeSkipped)
+	}
+	return run
+}
+
+// joinRunError 合并同一次运行中多个阶段的错误信息
+func joinRunError(prev, msg string) string {
+	if prev == "" {
+		return msg
+	}
+	return prev + "; " + msg
+}
+
+// observeLines 更新各线路的候选数量、Active 数量以及最佳分数/延迟指标
+func observeLines(selected map[string]models.LineResult) {
+	metrics.QualifiedIPs.Reset()
+	metrics.ActiveIPs.Reset()
+	metrics.ActiveBestScore.Reset()
+	metrics.ActiveBestLatency.Reset()
+	for key, lr := range selected {
+		metrics.QualifiedIPs.Set(float64(len(lr.Candidates)), key)
+		metrics.ActiveIPs.Set(float64(len(lr.Active)), key)
+		if len(lr.Active) == 0 {
+			continue
+		}
+		bestScore, bestLatency := lr.Active[0].Score, lr.Active[0].LatencyMs
+		for _, it := range lr.Active[1:] {
+			bestScore = max(bestScore, it.Score)
+			bestLatency = min(bestLatency, it.LatencyMs)
+		}
+		metrics.ActiveBestScore.Set(bestScore, key)
+		metrics.ActiveBestLatency.Set(float64(bestLatency), key)
+	}
+}
+
+// loadScoringHistory 在启用 EWMA 打分时读取时间窗口内的历史样本，返回 nil 表示仅使用本批次结果
+func loadScoringHistory(logger *slog.Logger, cfg *config.Config, st *store.Store) []store.Measurement {
+	if !cfg.Scoring.EWMA.Enabled {
+		return nil
+	}
+	if st == nil {
+		logger.Warn("'scoring.ewma' is enabled but history is disabled, scoring latest batch only")
+		return nil
+	}
+	history, err := st.Measurements(time.Now().Add(-cfg.Scoring.EWMA.Lookback()))
+	if err != nil {
+		logger.Warn("failed to load measurement history, falling back to latest batch", "error", err)
+		return nil
+	}
+	if history == nil {
+		history = []store.Measurement{}
+	}
+	logger.Debug("loaded historical samples for EWMA scoring", "samples", len(history), "half_life", cfg.Scoring.EWMA.HalfLife().String())
+	return history
+}
+
+// pruneHistory 按保留天数清理历史库中的过期记录
+func pruneHistory(logger *slog.Logger, st *store.Store, retentionDays int) {
+	if retentionDays <= 0 {
+		return
+	}
+	removed, err := st.Prune(time.Now().AddDate(0, 0, -retentionDays))
+	if err != nil {
+		logger.Warn("failed to prune history store", "error", err)
+		return
+	}
+	if removed > 0 {
+		logger.Info("pruned old history records", "removed", removed, "retention_days", retentionDays)
+	}
+}
+
+// probeRefillRounds 是探测后重新兜底并再次探测的最大轮数
+const probeRefillRounds = 3
+
+// markFailed 将本轮探测失败的 IP 累加到 excluded 中，返回本轮有 IP 被剔除的线路及其累计失败的 IP
+func markFailed(excluded map[string]map[string]bool, failures map[string][]probe.Result) map[string]map[string]bool {
+	pending := make(map[string]map[string]bool, len(failures))
+	for key, results := range failures {
+		if excluded[key] == nil {
+			excluded[key] = make(map[string]bool)
+		}
+		for _, r := range results {
+			excluded[key][r.IP] = true
+		}
+		pending[key] = excluded[key]
+	}
+	return pending
+}
+
+// [新增] probeGroups 按记录组分别探测 (各组使用自己的记录名作为 SNI)，合并结果
+func probeGroups(ctx context.Context, cfg *config.Config, selected map[string]models.LineResult) (map[string]models.LineResult, map[string][]probe.Result) {
+	byGroup := make(map[string]map[string]models.LineResult)
+	for key, lr := range selected {
+		if byGroup[lr.Group] == nil {
+			byGroup[lr.Group] = make(map[string]models.LineResult)
+		}
+		byGroup[lr.Group][key] = lr
+	}
+
+	verified := make(map[string]models.LineResult, len(selected))
+	failures := make(map[string][]probe.Result)
+	for groupID, lines := range byGroup {
+		group, _ := cfg.DNS.Group(groupID)
+		v, f := probe.New(cfg.Probe, group.RecordName()).VerifyActive(ctx, lines, func(key string) float64 {
+			_, operator, _ := models.SplitLineKey(key)
+			ln, _ := group.Line(operator)
+			return ln.MaxScoreGap
+		})
+		maps.Copy(verified, v)
+		maps.Copy(failures, f)
+	}
+	return verified, failures
+}
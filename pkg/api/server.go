@@ -0,0 +1,123 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"controller/pkg/status"
+)
+
+// Server 提供只读的状态查询接口和手动触发任务的接口，所有端点都需要 Bearer Token
+type Server struct {
+	token   string
+	reg     *status.Registry
+	trigger func() (queued, ok bool) // 触发一次任务: 已开始运行、排队等待当前运行结束后运行，或被跳过 (ok 为 false)
+	nextRun func() time.Time         // 下一次定时任务的时间
+	mux     *http.ServeMux
+	srv     *http.Server
+}
+
+func NewServer(addr, token string, reg *status.Registry, trigger func() (queued, ok bool), nextRun func() time.Time) *Server {
+	s := &Server{
+		token:   token,
+		reg:     reg,
+		trigger: trigger,
+		nextRun: nextRun,
+		mux:     http.NewServeMux(),
+	}
+	s.mux.Handle("GET /status", s.auth(s.handleStatus))
+	s.mux.Handle("GET /lines", s.auth(s.handleLines))
+	s.mux.Handle("GET /devices", s.auth(s.handleDevices))
+	s.mux.Handle("POST /run", s.auth(s.handleRun))
+	s.srv = &http.Server{
+		Addr:              addr,
+		Handler:           s.mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return s
+}
+
+// Handle 注册额外的端点 (如 /metrics)，requireAuth 为 false 时无需 Token
+func (s *Server) Handle(pattern string, h http.Handler, requireAuth bool) {
+	if requireAuth {
+		h = s.auth(h.ServeHTTP)
+	}
+	s.mux.Handle(pattern, h)
+}
+
+// Start 在后台开始监听
+func (s *Server) Start() {
+	go func() {
+		slog.Info("HTTP API listening", "addr", s.srv.Addr)
+		if err := s.srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("HTTP API server stopped", "error", err)
+		}
+	}()
+}
+
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.srv.Shutdown(ctx)
+}
+
+func (s *Server) auth(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(s.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="controller"`)
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+		next(w, r)
+	})
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	lastRun, running := s.reg.LastRun()
+	resp := struct {
+		Running       bool                  `json:"running"`
+		Role          string                `json:"role,omitempty"`
+		LastRun       *status.Run           `json:"last_run"`
+		NextRun       *time.Time            `json:"next_run"`
+		PendingUpload *status.PendingUpload `json:"pending_upload,omitempty"`
+	}{Running: running, Role: s.reg.Role(), LastRun: lastRun, PendingUpload: s.reg.PendingUpload()}
+	if next := s.nextRun(); !next.IsZero() {
+		resp.NextRun = &next
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleLines(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.reg.Lines())
+}
+
+func (s *Server) handleDevices(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.reg.Devices())
+}
+
+func (s *Server) handleRun(w http.ResponseWriter, r *http.Request) {
+	queued, ok := s.trigger()
+	switch {
+	case !ok:
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "a run is already in progress"})
+	case queued:
+		writeJSON(w, http.StatusAccepted, map[string]string{"status": "queued"})
+	default:
+		writeJSON(w, http.StatusAccepted, map[string]string{"status": "triggered"})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		slog.Warn("failed to write API response", "error", err)
+	}
+}
@@ -4,6 +4,7 @@ package config
 
 import (
 	"os"
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
@@ -11,9 +12,13 @@ import (
 type Huawei struct {
 	Enabled   bool   `yaml:"enabled"`
 	ProjectID string `yaml:"project_id"`
-	AccessKey string `yaml:"access_key"`
-	SecretKey string `yaml:"secret_key"`
+	AccessKey string `yaml:"access_key" secret:"true"`
+	SecretKey string `yaml:"secret_key" secret:"true"`
 	Region    string `yaml:"region"`
+
+	// [新增] 从文件读取密钥 (Docker/K8s secrets)，设置时优先于上面的内联值
+	AccessKeyFile string `yaml:"access_key_file"`
+	SecretKeyFile string `yaml:"secret_key_file"`
 }
 
 type Config struct {
@@ -23,37 +28,663 @@ type Config struct {
 	} `yaml:"cron"`
 
 	Gist struct {
-		Token                  string   `yaml:"token"`
+		Token                  string   `yaml:"token" secret:"true"`
+		TokenFile              string   `yaml:"token_file"` // [新增] 从文件读取 token
 		ProxyPrefix            string   `yaml:"proxy_prefix"`
 		DeviceGists            []string `yaml:"device_gists"`
 		ResultGistID           string   `yaml:"result_gist_id"`
 		GistUpdateCheckMinutes int      `yaml:"gist_update_check_minutes"` // [修改]
 	} `yaml:"gist"`
 
-	DNS struct {
-		ZoneId    string `yaml:"zone_id"`
-		Domain    string `yaml:"domain"`
-		Subdomain string `yaml:"subdomain"`
-		TTL       int    `yaml:"ttl"`
-		Lines     []Line `yaml:"lines"`
-	} `yaml:"dns"`
+	DNS       DNS        `yaml:"dns"`
+	Operators []Operator `yaml:"operators"` // [新增] 为空时使用 DefaultOperators
 
 	Huawei     Huawei     `yaml:"huawei"`
 	Scoring    Scoring    `yaml:"scoring"`
 	Thresholds Thresholds `yaml:"thresholds"`
+	History    History    `yaml:"history"` // [新增]
+	Probe      Probe      `yaml:"probe"`   // [新增]
+	Monitor    Monitor    `yaml:"monitor"` // [新增]
+	API        API        `yaml:"api"`     // [新增]
+	Notify     Notify     `yaml:"notify"`  // [新增]
+	Log        Log        `yaml:"log"`     // [新增]
+	Reload     Reload     `yaml:"reload"`  // [新增]
+	Run        Run        `yaml:"run"`     // [新增]
+	Leader     Leader     `yaml:"leader"`  // [新增]
+	Publish    Publish    `yaml:"publish"` // [新增]
+	State      State      `yaml:"state"`   // [新增]
+}
+
+// State 本地状态目录设置
+type State struct {
+	// 保存 state.json、历史库等本地状态的目录, 留空时使用命令行 --state-dir (默认 state)
+	Dir string `yaml:"dir"`
+}
+
+// Publish 结果 Gist 的写入时机及其中除各线路 JSON 之外的附加文件
+type Publish struct {
+	Mode  string `yaml:"mode"`  // [新增] always | on_change (默认) | never, 与 DNS 是否更新无关
+	Prune string `yaml:"prune"` // [新增] expire (默认) | delete | off, 处理本次不再生成的旧文件
+
+	Summary     bool `yaml:"summary"`      // summary.md: 各线路的 IP 表格, 标出 Active IP
+	Manifest    bool `yaml:"manifest"`     // manifest.json: 运行 ID、参与的设备、阈值权重和各线路的 DNS 结果
+	HistorySize int  `yaml:"history_size"` // history.jsonl 保留最近多少次的优选结果, 0 表示不生成
+
+	Outputs []Output `yaml:"outputs"` // [新增] 供路由器等下游工具直接使用的 IP 池文件
+}
+
+// 结果 Gist 的写入时机。内容 (不含时间戳) 与上次写入相同时始终跳过
+const (
+	PublishAlways   = "always"    // 每次运行都写入
+	PublishOnChange = "on_change" // 只在某条线路的候选 IP 集合变化时写入
+	PublishNever    = "never"     // 不写入
+)
+
+// ModeOrDefault 返回写入时机，未配置时为 on_change
+func (p Publish) ModeOrDefault() string {
+	if p.Mode != "" {
+		return p.Mode
+	}
+	return PublishOnChange
+}
+
+// 结果 Gist 中本次不再生成的文件 (如某条线路今天没有合格 IP) 的处理方式
+const (
+	PruneExpire = "expire" // 线路文件改为空结果并标记 expired, 其他文件删除
+	PruneDelete = "delete" // 删除
+	PruneOff    = "off"    // 保留旧文件
+)
+
+// PruneOrDefault 返回旧文件的处理方式，未配置时为 expire
+func (p Publish) PruneOrDefault() string {
+	if p.Prune != "" {
+		return p.Prune
+	}
+	return PruneExpire
+}
+
+// 输出格式
+const (
+	OutputIPTxt   = "iptxt"   // 每行一个 IP
+	OutputCFST    = "cfst"    // CloudflareST -f 参数使用的 IP 列表 (去重)
+	OutputClash   = "clash"   // Clash / mihomo proxy-provider
+	OutputSingBox = "singbox" // sing-box outbounds
+	OutputV2rayN  = "v2rayn"  // v2rayN 订阅 (base64 编码的分享链接)
+)
+
+// Output 是写入结果 Gist 的一个 IP 池文件
+type Output struct {
+	Format string   `yaml:"format"` // iptxt | cfst | clash | singbox | v2rayn
+	File   string   `yaml:"file"`   // 文件名, 包含 {line} 时每条线路生成一个文件; 为空时使用 DefaultFile
+	Lines  []string `yaml:"lines"`  // 只包含这些线路 (如 cu-v4, cdn/cu-v4), 为空表示全部
+	Pool   string   `yaml:"pool"`   // candidates (默认, 全部合格 IP) | active (仅已发布的 IP)
+	Proxy  Proxy    `yaml:"proxy"`  // clash / singbox / v2rayn 使用的节点模板, server 为池中的 IP
+}
+
+// 输出的 IP 池
+const (
+	PoolCandidates = "candidates"
+	PoolActive     = "active"
+)
+
+// DefaultFile 返回该格式的默认文件名
+func (o Output) DefaultFile() string {
+	if o.File != "" {
+		return o.File
+	}
+	switch o.Format {
+	case OutputIPTxt:
+		return "{line}.txt"
+	case OutputCFST:
+		return "cfst-ip.txt"
+	case OutputClash:
+		return "clash-provider.yaml"
+	case OutputSingBox:
+		return "sing-box-outbounds.json"
+	case OutputV2rayN:
+		return "v2rayn-sub.txt"
+	}
+	return ""
+}
+
+// NeedsProxy 返回该格式是否需要节点模板
+func (o Output) NeedsProxy() bool {
+	return o.Format == OutputClash || o.Format == OutputSingBox || o.Format == OutputV2rayN
+}
+
+// 节点协议
+const (
+	ProxyVLESS  = "vless"
+	ProxyVMess  = "vmess"
+	ProxyTrojan = "trojan"
+)
+
+// Proxy 是生成代理节点时使用的模板, 每个 IP 生成一个节点
+type Proxy struct {
+	Type     string `yaml:"type"` // vless | vmess | trojan
+	Port     int    `yaml:"port"` // 默认 443
+	UUID     string `yaml:"uuid" secret:"true"`
+	Password string `yaml:"password" secret:"true"` // trojan 使用
+	Network  string `yaml:"network"`                // tcp | ws (默认) | grpc
+	Host     string `yaml:"host"`                   // ws 的 Host 头
+	Path     string `yaml:"path"`                   // ws 路径或 grpc 的 service name
+	TLS      bool   `yaml:"tls"`
+	SNI      string `yaml:"sni"` // 为空时使用 host
+
+	// [新增] 从文件读取对应字段 (Docker/K8s secrets)，设置时优先于内联值
+	UUIDFile     string `yaml:"uuid_file"`
+	PasswordFile string `yaml:"password_file"`
+}
+
+// PortOrDefault 返回节点端口，未配置时为 443
+func (p Proxy) PortOrDefault() int {
+	if p.Port > 0 {
+		return p.Port
+	}
+	return 443
+}
+
+// NetworkOrDefault 返回传输方式，未配置时为 ws
+func (p Proxy) NetworkOrDefault() string {
+	if p.Network != "" {
+		return p.Network
+	}
+	return "ws"
+}
+
+// ServerName 返回 TLS SNI，未配置时使用 host
+func (p Proxy) ServerName() string {
+	if p.SNI != "" {
+		return p.SNI
+	}
+	return p.Host
+}
+
+// 选主锁后端
+const (
+	LeaderBackendFile       = "file"
+	LeaderBackendKubernetes = "kubernetes"
+	LeaderBackendGist       = "gist"
+)
+
+// Leader 多副本部署时的选主设置，只有 leader 会更新 DNS 和写结果 Gist
+type Leader struct {
+	Enabled      bool   `yaml:"enabled"`
+	Backend      string `yaml:"backend"`       // file | kubernetes | gist
+	Identity     string `yaml:"identity"`      // 默认 "主机名-进程号"
+	LeaseSeconds int    `yaml:"lease_seconds"` // 租约时长, 默认 30 秒, gist 后端默认 900 秒 (file 后端不使用)
+	RenewSeconds int    `yaml:"renew_seconds"` // 续约间隔, 默认 lease_seconds 的 1/3, gist 后端不少于 300 秒
+
+	File struct {
+		Path string `yaml:"path"` // 默认为系统临时目录下的 cfst-controller.lock
+	} `yaml:"file"`
+	Kubernetes struct {
+		APIURL    string `yaml:"api_url"`   // 默认使用集群内地址
+		Namespace string `yaml:"namespace"` // 默认当前 Pod 所在的 namespace
+		Name      string `yaml:"name"`      // Lease 对象名称
+		TokenFile string `yaml:"token_file"`
+		CAFile    string `yaml:"ca_file"`
+	} `yaml:"kubernetes"`
+	Gist struct {
+		GistID string `yaml:"gist_id"` // 默认使用 gist.result_gist_id
+		File   string `yaml:"file"`    // 默认 leader.json
+	} `yaml:"gist"`
+}
+
+// gist 后端每次续约都会产生一次 Gist 修订，续约间隔不能太短，以免触发 GitHub 对内容创建的限流
+const (
+	GistLeaderMinRenew     = 5 * time.Minute
+	gistLeaderDefaultLease = 15 * time.Minute
+)
+
+// LeaseDuration 返回租约时长，未设置时 gist 后端默认 15 分钟，其余后端默认 30 秒
+func (l Leader) LeaseDuration() time.Duration {
+	if l.LeaseSeconds > 0 {
+		return time.Duration(l.LeaseSeconds) * time.Second
+	}
+	if l.Backend == LeaderBackendGist {
+		return gistLeaderDefaultLease
+	}
+	return 30 * time.Second
+}
+
+// RenewInterval 返回续约间隔，默认为租约时长的 1/3 (gist 后端不短于 GistLeaderMinRenew)
+func (l Leader) RenewInterval() time.Duration {
+	if l.RenewSeconds > 0 {
+		return time.Duration(l.RenewSeconds) * time.Second
+	}
+	d := l.LeaseDuration() / 3
+	if l.Backend == LeaderBackendGist && d < GistLeaderMinRenew {
+		d = GistLeaderMinRenew
+	}
+	return d
+}
+
+// 上一次运行尚未结束时触发新运行的处理方式
+const (
+	OverlapSkip  = "skip"  // 跳过本次触发 (默认)
+	OverlapQueue = "queue" // 排队, 上一次结束后立即再运行一次 (多次触发只排队一次)
+)
+
+// Run 单次任务的执行控制
+type Run struct {
+	Overlap              string `yaml:"overlap"`                // skip | queue
+	TimeoutMinutes       int    `yaml:"timeout_minutes"`        // 单次运行的超时时间, 默认 10 分钟
+	ShutdownGraceSeconds int    `yaml:"shutdown_grace_seconds"` // 退出时等待进行中运行的时间, 超时后取消, 默认 30 秒
+}
+
+// Timeout 返回单次运行的超时时间
+func (r Run) Timeout() time.Duration {
+	if r.TimeoutMinutes > 0 {
+		return time.Duration(r.TimeoutMinutes) * time.Minute
+	}
+	return 10 * time.Minute
+}
+
+// ShutdownGrace 返回退出时等待进行中运行的时间
+func (r Run) ShutdownGrace() time.Duration {
+	if r.ShutdownGraceSeconds > 0 {
+		return time.Duration(r.ShutdownGraceSeconds) * time.Second
+	}
+	return 30 * time.Second
+}
+
+// Reload 配置文件热重载设置 (serve 模式下也可以发送 SIGHUP 触发重载)
+type Reload struct {
+	Watch           bool `yaml:"watch"`            // 是否监视配置文件变化
+	IntervalSeconds int  `yaml:"interval_seconds"` // 检查间隔, 默认 5 秒
+}
+
+// Interval 返回检查配置文件变化的间隔
+func (r Reload) Interval() time.Duration {
+	if r.IntervalSeconds > 0 {
+		return time.Duration(r.IntervalSeconds) * time.Second
+	}
+	return 5 * time.Second
+}
+
+// DNS 记录设置。
+// [修改] 支持多个记录组 (groups)；旧版的单组写法 (zone_id/domain/subdomain/ttl/lines) 仍然可用，
+// 会被视为一个没有 id 的记录组，两种写法不能同时使用
+type DNS struct {
+	ZoneId    string `yaml:"zone_id"`
+	Domain    string `yaml:"domain"`
+	Subdomain string `yaml:"subdomain"`
+	TTL       int    `yaml:"ttl"`
+	Lines     []Line `yaml:"lines"`
+
+	Groups []RecordGroup `yaml:"groups"` // [新增]
+}
+
+// [新增] Operator 定义一个运营商 (线路): 设备结果文件名中的代码、显示名称以及各 DNS 服务商中的线路 ID
+type Operator struct {
+	Code          string            `yaml:"code"`           // 如 ct, 对应设备文件 results-ct-xxx-v4.json
+	Name          string            `yaml:"name"`           // 如 中国电信
+	ProviderLines map[string]string `yaml:"provider_lines"` // 服务商 -> 线路 ID, 如 huawei: Dianxin
+}
+
+// DefaultOperators 是未配置 operators 时使用的运营商
+var DefaultOperators = []Operator{
+	{Code: "ct", Name: "中国电信", ProviderLines: map[string]string{ProviderHuawei: "Dianxin"}},
+	{Code: "cu", Name: "中国联通", ProviderLines: map[string]string{ProviderHuawei: "Liantong"}},
+	{Code: "cm", Name: "中国移动", ProviderLines: map[string]string{ProviderHuawei: "Yidong"}},
+}
+
+// OperatorList 返回配置的运营商，未配置时返回 DefaultOperators
+func (c *Config) OperatorList() []Operator {
+	if len(c.Operators) > 0 {
+		return c.Operators
+	}
+	return DefaultOperators
+}
+
+// OperatorCodes 返回所有运营商代码
+func (c *Config) OperatorCodes() []string {
+	ops := c.OperatorList()
+	codes := make([]string, 0, len(ops))
+	for _, op := range ops {
+		codes = append(codes, op.Code)
+	}
+	return codes
+}
+
+// Operator 按代码查找运营商
+func (c *Config) Operator(code string) (Operator, bool) {
+	for _, op := range c.OperatorList() {
+		if op.Code == code {
+			return op, true
+		}
+	}
+	return Operator{}, false
+}
+
+// OperatorName 返回运营商的显示名称，未设置时返回代码本身
+func (c *Config) OperatorName(code string) string {
+	if op, ok := c.Operator(code); ok && op.Name != "" {
+		return op.Name
+	}
+	return code
+}
+
+// DNS 服务商
+const ProviderHuawei = "huawei"
+
+// KnownProviders 是支持的 DNS 服务商
+var KnownProviders = []string{ProviderHuawei}
+
+// RecordGroup 是一组共用同一记录名的 DNS 记录 (如 cf.example.com)，每条线路对应其中的一个记录集
+type RecordGroup struct {
+	ID        string `yaml:"id"`       // 组标识, 用于线路 key (如 cdn/cu-v4) 和结果文件名
+	Provider  string `yaml:"provider"` // 默认 huawei
+	ZoneId    string `yaml:"zone_id"`
+	Domain    string `yaml:"domain"`
+	Subdomain string `yaml:"subdomain"`
+	TTL       int    `yaml:"ttl"`
+	Lines     []Line `yaml:"lines"`
+}
+
+// RecordName 返回不带末尾点的完整记录名，如 cf.example.com
+func (g RecordGroup) RecordName() string {
+	if g.Subdomain == "" || g.Subdomain == "@" {
+		return g.Domain
+	}
+	return g.Subdomain + "." + g.Domain
+}
+
+func (g RecordGroup) ProviderOrDefault() string {
+	if g.Provider != "" {
+		return g.Provider
+	}
+	return ProviderHuawei
+}
+
+// Line 返回该组中指定运营商的线路配置
+func (g RecordGroup) Line(operator string) (Line, bool) {
+	for _, l := range g.Lines {
+		if l.Operator == operator {
+			return l, true
+		}
+	}
+	return Line{}, false
+}
+
+// RecordGroups 返回所有记录组；使用旧版单组写法时返回一个 ID 为空的组
+func (d DNS) RecordGroups() []RecordGroup {
+	if len(d.Groups) > 0 {
+		return d.Groups
+	}
+	return []RecordGroup{{ZoneId: d.ZoneId, Domain: d.Domain, Subdomain: d.Subdomain, TTL: d.TTL, Lines: d.Lines}}
+}
+
+// Group 按 ID 查找记录组
+func (d DNS) Group(id string) (RecordGroup, bool) {
+	for _, g := range d.RecordGroups() {
+		if g.ID == id {
+			return g, true
+		}
+	}
+	return RecordGroup{}, false
+}
+
+// legacy 返回是否使用了旧版单组写法的字段
+func (d DNS) legacy() bool {
+	return d.ZoneId != "" || d.Domain != "" || d.Subdomain != "" || d.TTL != 0 || len(d.Lines) > 0
+}
+
+// Log 日志输出设置
+type Log struct {
+	Level  string `yaml:"level"`  // debug | info | warn | error, 默认 info
+	Format string `yaml:"format"` // text | json, 默认 text
+}
+
+// Notify DNS 变更和失败通知设置
+type Notify struct {
+	// 相同事件 (同一线路的同一组 IP、同一阶段的同一对象失败) 的最小发送间隔
+	MinIntervalMinutes int             `yaml:"min_interval_minutes"`
+	Channels           []NotifyChannel `yaml:"channels"`
+}
+
+// NotifyChannel 通知渠道，不同 type 使用的字段不同
+type NotifyChannel struct {
+	Type        string `yaml:"type"`         // webhook | telegram | dingtalk | feishu | wecom | serverchan | bark | smtp
+	MinSeverity string `yaml:"min_severity"` // info | warning | error, 默认 info
+
+	URL       string `yaml:"url" secret:"true"`        // webhook / dingtalk / feishu / wecom 的地址, bark 的服务器地址
+	Secret    string `yaml:"secret" secret:"true"`     // dingtalk / feishu 的加签密钥
+	BotToken  string `yaml:"bot_token" secret:"true"`  // telegram
+	ChatID    string `yaml:"chat_id"`                  // telegram
+	SendKey   string `yaml:"send_key" secret:"true"`   // serverchan
+	DeviceKey string `yaml:"device_key" secret:"true"` // bark
+
+	SMTPHost string   `yaml:"smtp_host"`
+	SMTPPort int      `yaml:"smtp_port"` // 默认 587
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password" secret:"true"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+
+	// [新增] 从文件读取对应字段 (Docker/K8s secrets)，设置时优先于内联值
+	URLFile       string `yaml:"url_file"`
+	SecretFile    string `yaml:"secret_file"`
+	BotTokenFile  string `yaml:"bot_token_file"`
+	SendKeyFile   string `yaml:"send_key_file"`
+	DeviceKeyFile string `yaml:"device_key_file"`
+	PasswordFile  string `yaml:"password_file"`
+}
+
+// API 内置 HTTP 接口设置
+type API struct {
+	Enabled   bool   `yaml:"enabled"`
+	Listen    string `yaml:"listen"`
+	Token     string `yaml:"token" secret:"true"` // Bearer Token, 启用时必填
+	TokenFile string `yaml:"token_file"`          // [新增] 从文件读取 token
+
+	// [新增] 在同一端口上提供 Prometheus 格式的 /metrics
+	Metrics     bool `yaml:"metrics"`
+	MetricsAuth bool `yaml:"metrics_auth"` // /metrics 是否同样要求 Bearer Token
+}
+
+// Monitor 两次定时任务之间对已发布 IP 的持续监控设置 (探测参数沿用 probe 配置)
+type Monitor struct {
+	Enabled          bool `yaml:"enabled"`
+	IntervalSeconds  int  `yaml:"interval_seconds"`
+	FailureThreshold int  `yaml:"failure_threshold"` // 连续失败多少次后触发紧急切换
+}
+
+// Interval 返回监控间隔，未配置时默认为 60 秒
+func (m Monitor) Interval() time.Duration {
+	if m.IntervalSeconds > 0 {
+		return time.Duration(m.IntervalSeconds) * time.Second
+	}
+	return 60 * time.Second
+}
+
+func (m Monitor) FailureThresholdOrDefault() int {
+	if m.FailureThreshold > 0 {
+		return m.FailureThreshold
+	}
+	return 3
+}
+
+// Probe 发布前由控制器对 Active IP 进行的主动探测设置
+type Probe struct {
+	Enabled            bool   `yaml:"enabled"`
+	Port               int    `yaml:"port"`      // 默认 443
+	SNI                string `yaml:"sni"`       // 为空时使用 subdomain.domain
+	TraceURL           string `yaml:"trace_url"` // 可选, 握手成功后再 GET 该地址, 要求返回 2xx
+	TimeoutSeconds     int    `yaml:"timeout_seconds"`
+	Concurrency        int    `yaml:"concurrency"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+// History 本地历史数据存储设置
+type History struct {
+	Enabled       bool   `yaml:"enabled"`
+	Path          string `yaml:"path"`
+	RetentionDays int    `yaml:"retention_days"` // 0 表示永久保留
 }
 
 type Line struct {
-	Operator        string `yaml:"operator"`
-	ARecordsetID    string `yaml:"a_recordset_id"`
-	AAAARecordsetID string `yaml:"aaaa_recordset_id"`
-	Cap             int    `yaml:"cap"`
+	Operator        string   `yaml:"operator"`
+	ARecordsetID    string   `yaml:"a_recordset_id"`
+	AAAARecordsetID string   `yaml:"aaaa_recordset_id"`
+	Cap             int      `yaml:"cap"`
+	Fallback        Fallback `yaml:"fallback"` // [新增]
+
+	// [新增] 按 IP 版本单独设置发布数量, 0 表示使用 cap
+	CapV4 int `yaml:"cap_v4"`
+	CapV6 int `yaml:"cap_v6"`
+	// [新增] 至少需要的 Active IP 数量，不足时按 fallback 策略处理而不是发布过少的 IP
+	MinActive int `yaml:"min_active"`
+	// [新增] 相对分差截断: 与第一名的分差超过 |第一名分数| * max_score_gap 的 IP 不发布, 0 表示不截断
+	MaxScoreGap float64 `yaml:"max_score_gap"`
+
+	// [新增] 按 IP 版本覆盖全局阈值和打分权重，未设置的字段继承全局配置
+	V4 LineOverride `yaml:"v4"`
+	V6 LineOverride `yaml:"v6"`
+}
+
+type LineOverride struct {
+	Thresholds ThresholdsOverride `yaml:"thresholds"`
+	Scoring    ScoringOverride    `yaml:"scoring"`
+}
+
+// ThresholdsOverride 中的 nil 字段表示继承全局值
+type ThresholdsOverride struct {
+	MaxLatencyMs    *int     `yaml:"max_latency_ms"`
+	MinDownloadMbps *float64 `yaml:"min_download_mbps"`
+	MaxLossPct      *float64 `yaml:"max_loss_pct"`
+}
+
+// ScoringOverride 中的 nil 字段表示继承全局值
+type ScoringOverride struct {
+	LatencyWeight *float64 `yaml:"latency_weight"`
+	SpeedWeight   *float64 `yaml:"speed_weight"`
+	LossWeight    *float64 `yaml:"loss_weight"`
+}
+
+// Effective 返回该线路指定 IP 版本合并覆盖项后的打分权重和阈值
+func (l Line) Effective(ipVersion string, sc Scoring, th Thresholds) (Scoring, Thresholds) {
+	o := l.V4
+	if ipVersion == "v6" {
+		o = l.V6
+	}
+	if o.Thresholds.MaxLatencyMs != nil {
+		th.MaxLatencyMs = *o.Thresholds.MaxLatencyMs
+	}
+	if o.Thresholds.MinDownloadMbps != nil {
+		th.MinDownloadMbps = *o.Thresholds.MinDownloadMbps
+	}
+	if o.Thresholds.MaxLossPct != nil {
+		th.MaxLossPct = *o.Thresholds.MaxLossPct
+	}
+	if o.Scoring.LatencyWeight != nil {
+		sc.LatencyWeight = *o.Scoring.LatencyWeight
+	}
+	if o.Scoring.SpeedWeight != nil {
+		sc.SpeedWeight = *o.Scoring.SpeedWeight
+	}
+	if o.Scoring.LossWeight != nil {
+		sc.LossWeight = *o.Scoring.LossWeight
+	}
+	return sc, th
+}
+
+// CapFor 返回该线路指定 IP 版本的发布数量上限
+func (l Line) CapFor(ipVersion string) int {
+	if ipVersion == "v6" && l.CapV6 > 0 {
+		return l.CapV6
+	}
+	if ipVersion == "v4" && l.CapV4 > 0 {
+		return l.CapV4
+	}
+	return l.Cap
+}
+
+// MinActiveOrDefault 返回至少需要的 Active IP 数量，未配置时为 1
+func (l Line) MinActiveOrDefault() int {
+	if l.MinActive > 0 {
+		return l.MinActive
+	}
+	return 1
+}
+
+// RecordsetID 返回该线路指定 IP 版本 ("v4"/"v6") 对应的记录集 ID
+func (l Line) RecordsetID(ipVersion string) string {
+	if ipVersion == "v6" {
+		return l.AAAARecordsetID
+	}
+	return l.ARecordsetID
+}
+
+// 线路没有合格 IP 时的兜底策略
+const (
+	FallbackKeep   = "keep"   // 保留 DNS 现有记录 (默认)
+	FallbackRelax  = "relax"  // 逐步放宽阈值重新筛选
+	FallbackBorrow = "borrow" // 借用其他运营商线路的优选 IP
+	FallbackStatic = "static" // 发布配置的静态 IP
+)
+
+type Fallback struct {
+	Policy      string   `yaml:"policy"`
+	RelaxSteps  int      `yaml:"relax_steps"`  // 放宽的最大步数, 默认 3
+	RelaxFactor float64  `yaml:"relax_factor"` // 每步放宽的比例, 默认 0.2
+	BorrowFrom  []string `yaml:"borrow_from"`  // 按顺序尝试借用的运营商
+	StaticV4    []string `yaml:"static_v4"`
+	StaticV6    []string `yaml:"static_v6"`
+}
+
+func (f Fallback) RelaxStepsOrDefault() int {
+	if f.RelaxSteps > 0 {
+		return f.RelaxSteps
+	}
+	return 3
+}
+
+func (f Fallback) RelaxFactorOrDefault() float64 {
+	if f.RelaxFactor > 0 {
+		return f.RelaxFactor
+	}
+	return 0.2
+}
+
+// StaticIPs 返回指定 IP 版本的静态兜底 IP
+func (f Fallback) StaticIPs(ipVersion string) []string {
+	if ipVersion == "v6" {
+		return f.StaticV6
+	}
+	return f.StaticV4
 }
 
 type Scoring struct {
 	LatencyWeight float64 `yaml:"latency_weight"`
 	SpeedWeight   float64 `yaml:"speed_weight"`
 	LossWeight    float64 `yaml:"loss_weight"`
+	EWMA          EWMA    `yaml:"ewma"` // [新增]
+}
+
+// EWMA 基于历史样本的时间衰减打分设置 (需要启用 history)
+type EWMA struct {
+	Enabled         bool    `yaml:"enabled"`
+	HalfLifeMinutes int     `yaml:"half_life_minutes"`
+	LookbackMinutes int     `yaml:"lookback_minutes"` // 0 表示取 6 个半衰期
+	MissingPenalty  float64 `yaml:"missing_penalty"`  // IP 每缺席一个半衰期扣除的分数, 0 表示不惩罚
+}
+
+// Lookback 返回读取历史样本的时间窗口
+func (e EWMA) Lookback() time.Duration {
+	if e.LookbackMinutes > 0 {
+		return time.Duration(e.LookbackMinutes) * time.Minute
+	}
+	return 6 * e.HalfLife()
+}
+
+// HalfLife 返回半衰期，未配置时默认为 60 分钟
+func (e EWMA) HalfLife() time.Duration {
+	if e.HalfLifeMinutes > 0 {
+		return time.Duration(e.HalfLifeMinutes) * time.Minute
+	}
+	return 60 * time.Minute
 }
 
 type Thresholds struct {
@@ -62,18 +693,48 @@ type Thresholds struct {
 	MaxLossPct      float64 `yaml:"max_loss_pct"`
 }
 
+// Load 严格解析配置文件 (未知字段视为错误)，应用环境变量和密钥文件后进行校验
 func Load(path string) (*Config, error) {
+	// [修改] 依次应用 CFST_ 环境变量覆盖、*_file 密钥文件和密钥字段中的 ${VAR} 展开
+	cfg, err := parse(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := resolveSecrets(cfg); err != nil {
+		return nil, err
+	}
+	cfg.Huawei.ProjectID = os.ExpandEnv(cfg.Huawei.ProjectID)
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// [新增] LoadLines 严格解析配置文件并应用环境变量，但不读取密钥、只校验线路相关的配置，
+// 用于在没有密钥的主机上展示线路 (show lines)
+func LoadLines(path string) (*Config, error) {
+	cfg, err := parse(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.ValidateLines(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// parse 严格解析配置文件并应用 CFST_ 环境变量覆盖
+func parse(path string) (*Config, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
 	var cfg Config
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return nil, err
+	}
+	if err := applyEnvOverrides(&cfg, os.Environ()); err != nil {
 		return nil, err
 	}
-	cfg.Gist.Token = os.ExpandEnv(cfg.Gist.Token)
-	cfg.Huawei.ProjectID = os.ExpandEnv(cfg.Huawei.ProjectID)
-	cfg.Huawei.AccessKey = os.ExpandEnv(cfg.Huawei.AccessKey)
-	cfg.Huawei.SecretKey = os.ExpandEnv(cfg.Huawei.SecretKey)
 	return &cfg, nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,92 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Change 是两份配置之间的一处差异，带 secret 标签的字段的值会被隐去
+type Change struct {
+	Path string
+	Old  string
+	New  string
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s: %s -> %s", c.Path, c.Old, c.New)
+}
+
+// restartOnly 中的配置只在启动时读取，修改后需要重启才能生效
+var restartOnly = []string{"history.", "api.", "monitor.enabled", "reload.", "leader.", "state."}
+
+// RequiresRestart 返回该路径的修改是否需要重启才能生效
+func (c Change) RequiresRestart() bool {
+	for _, prefix := range restartOnly {
+		if strings.HasPrefix(c.Path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Diff 按 YAML 路径列出 old 到 new 的所有字段变化
+func Diff(old, new *Config) []Change {
+	var changes []Change
+	diffValue(reflect.ValueOf(old).Elem(), reflect.ValueOf(new).Elem(), "", false, &changes)
+	return changes
+}
+
+func diffValue(a, b reflect.Value, path string, secret bool, out *[]Change) {
+	switch a.Kind() {
+	case reflect.Struct:
+		t := a.Type()
+		for i := 0; i < t.NumField(); i++ {
+			tag, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+			childPath := tag
+			if path != "" {
+				childPath = path + "." + tag
+			}
+			diffValue(a.Field(i), b.Field(i), childPath, t.Field(i).Tag.Get("secret") == "true", out)
+		}
+	case reflect.Slice:
+		if a.Type().Elem().Kind() != reflect.Struct {
+			break
+		}
+		for i := 0; i < max(a.Len(), b.Len()); i++ {
+			itemPath := fmt.Sprintf("%s[%d]", path, i)
+			switch {
+			case i >= a.Len():
+				*out = append(*out, Change{Path: itemPath, Old: "<none>", New: "<added>"})
+			case i >= b.Len():
+				*out = append(*out, Change{Path: itemPath, Old: "<removed>", New: "<none>"})
+			default:
+				diffValue(a.Index(i), b.Index(i), itemPath, false, out)
+			}
+		}
+		return
+	}
+	if a.Kind() == reflect.Struct || reflect.DeepEqual(a.Interface(), b.Interface()) {
+		return
+	}
+	*out = append(*out, Change{Path: path, Old: formatValue(a, secret), New: formatValue(b, secret)})
+}
+
+func formatValue(v reflect.Value, secret bool) string {
+	if secret {
+		if v.String() == "" {
+			return `""`
+		}
+		return "<redacted>"
+	}
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return "<unset>"
+		}
+		return formatValue(v.Elem(), false)
+	case reflect.String:
+		return fmt.Sprintf("%q", v.String())
+	}
+	return fmt.Sprintf("%v", v.Interface())
+}
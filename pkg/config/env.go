@@ -0,0 +1,198 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// EnvPrefix 是配置覆盖环境变量的前缀。
+// 变量名由 YAML 路径转为大写并以 "_" 连接而成，例如 dns.zone_id -> CFST_DNS_ZONE_ID，
+// 列表元素使用下标，例如 dns.lines[0].cap -> CFST_DNS_LINES_0_CAP (元素须已在配置文件中存在)。
+// 字符串列表使用逗号分隔。任意变量名加上 _FILE 后缀时，值从该路径的文件中读取。
+const EnvPrefix = "CFST_"
+
+// envField 是一个可被环境变量覆盖的配置字段
+type envField struct {
+	path  string // YAML 路径, 用于错误信息
+	value reflect.Value
+}
+
+// warnedEnv 记录已经警告过的无法识别的变量名，避免每次重载配置时重复输出
+var warnedEnv sync.Map
+
+// applyEnvOverrides 将 environ 中以 CFST_ 开头的变量覆盖到配置上，无法解析的值作为问题一并返回。
+// 无法识别的变量名只记录警告并忽略: Kubernetes 会为同名 Service 注入 CFST_CONTROLLER_SERVICE_HOST 等变量
+func applyEnvOverrides(cfg *Config, environ []string) error {
+	fields := make(map[string]envField)
+	collectEnvFields(reflect.ValueOf(cfg).Elem(), strings.TrimSuffix(EnvPrefix, "_"), "", fields)
+
+	var names []string
+	env := make(map[string]string)
+	for _, kv := range environ {
+		name, val, ok := strings.Cut(kv, "=")
+		if ok && strings.HasPrefix(name, EnvPrefix) {
+			names = append(names, name)
+			env[name] = val
+		}
+	}
+	sort.Strings(names)
+
+	var ps problems
+	for _, name := range names {
+		raw := env[name]
+		f, ok := fields[name]
+		if !ok {
+			base, isFile := strings.CutSuffix(name, "_FILE")
+			if f, ok = fields[base]; !ok || !isFile {
+				if _, warned := warnedEnv.LoadOrStore(name, true); !warned {
+					slog.Warn("ignoring environment variable that matches no config field", "name", name)
+				}
+				continue
+			}
+			data, err := os.ReadFile(raw)
+			if err != nil {
+				ps.add(name, "failed to read %s: %v", f.path, err)
+				continue
+			}
+			raw = strings.TrimSpace(string(data))
+		}
+		if err := setFromString(f.value, raw); err != nil {
+			ps.add(name, "invalid value for %s: %v", f.path, err)
+		}
+	}
+	if len(ps) > 0 {
+		return &ValidationError{Problems: ps}
+	}
+	return nil
+}
+
+// collectEnvFields 递归收集所有标量字段，键为对应的环境变量名
+func collectEnvFields(v reflect.Value, name, path string, out map[string]envField) {
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			tag, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+			if tag == "" || tag == "-" {
+				continue
+			}
+			childPath := tag
+			if path != "" {
+				childPath = path + "." + tag
+			}
+			collectEnvFields(v.Field(i), name+"_"+strings.ToUpper(tag), childPath, out)
+		}
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Struct {
+			for i := 0; i < v.Len(); i++ {
+				collectEnvFields(v.Index(i), fmt.Sprintf("%s_%d", name, i), fmt.Sprintf("%s[%d]", path, i), out)
+			}
+			return
+		}
+		out[name] = envField{path: path, value: v}
+	default:
+		out[name] = envField{path: path, value: v}
+	}
+}
+
+func setFromString(v reflect.Value, raw string) error {
+	switch v.Kind() {
+	case reflect.Ptr:
+		elem := reflect.New(v.Type().Elem())
+		if err := setFromString(elem.Elem(), raw); err != nil {
+			return err
+		}
+		v.Set(elem)
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported list type %s", v.Type())
+		}
+		var items []string
+		for _, s := range strings.Split(raw, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				items = append(items, s)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// resolveSecrets 处理带 secret 标签的字段: 同级 <name>_file 字段非空时从文件读取，
+// 否则展开值中的 ${VAR}
+func resolveSecrets(cfg *Config) error {
+	var ps problems
+	walkSecrets(reflect.ValueOf(cfg).Elem(), "", &ps)
+	if len(ps) > 0 {
+		return &ValidationError{Problems: ps}
+	}
+	return nil
+}
+
+func walkSecrets(v reflect.Value, path string, ps *problems) {
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		files := make(map[string]reflect.Value)
+		for i := 0; i < t.NumField(); i++ {
+			tag, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+			files[tag] = v.Field(i)
+		}
+		for i := 0; i < t.NumField(); i++ {
+			tag, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+			childPath := tag
+			if path != "" {
+				childPath = path + "." + tag
+			}
+			f := v.Field(i)
+			if t.Field(i).Tag.Get("secret") != "true" {
+				walkSecrets(f, childPath, ps)
+				continue
+			}
+			if file, ok := files[tag+"_file"]; ok && file.String() != "" {
+				data, err := os.ReadFile(file.String())
+				if err != nil {
+					ps.add(childPath+"_file", "%v", err)
+					continue
+				}
+				f.SetString(strings.TrimSpace(string(data)))
+				continue
+			}
+			f.SetString(os.ExpandEnv(f.String()))
+		}
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Struct {
+			for i := 0; i < v.Len(); i++ {
+				walkSecrets(v.Index(i), fmt.Sprintf("%s[%d]", path, i), ps)
+			}
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// problemPaths 返回 err 中各问题的路径，err 为 nil 时返回 nil
+func problemPaths(t *testing.T, err error) []string {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	var ve *ValidationError
+	if !errors.As(err, &ve) {
+		t.Fatalf("error is %T, want *ValidationError: %v", err, err)
+	}
+	var paths []string
+	for _, p := range ve.Problems {
+		paths = append(paths, p.Path)
+	}
+	return paths
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestApplyEnvOverrides(t *testing.T) {
+	zoneFile := writeFile(t, "zone", "zone-from-file\n")
+
+	tests := []struct {
+		name         string
+		env          []string
+		check        func(*Config) bool
+		wantProblems []string
+	}{
+		{
+			name:  "string",
+			env:   []string{"CFST_DNS_ZONE_ID=z1"},
+			check: func(c *Config) bool { return c.DNS.ZoneId == "z1" },
+		},
+		{
+			name:  "int and float",
+			env:   []string{"CFST_THRESHOLDS_MAX_LATENCY_MS=120", "CFST_THRESHOLDS_MIN_DOWNLOAD_MBPS=7.5"},
+			check: func(c *Config) bool { return c.Thresholds.MaxLatencyMs == 120 && c.Thresholds.MinDownloadMbps == 7.5 },
+		},
+		{
+			name:  "bool",
+			env:   []string{"CFST_HISTORY_ENABLED=true"},
+			check: func(c *Config) bool { return c.History.Enabled },
+		},
+		{
+			name:  "string list is comma separated",
+			env:   []string{"CFST_GIST_DEVICE_GISTS=a, b,,c"},
+			check: func(c *Config) bool { return slices.Equal(c.Gist.DeviceGists, []string{"a", "b", "c"}) },
+		},
+		{
+			name:  "list element by index",
+			env:   []string{"CFST_DNS_LINES_1_CAP=4"},
+			check: func(c *Config) bool { return c.DNS.Lines[0].Cap == 2 && c.DNS.Lines[1].Cap == 4 },
+		},
+		{
+			name: "pointer override",
+			env:  []string{"CFST_DNS_LINES_0_V6_THRESHOLDS_MAX_LATENCY_MS=300"},
+			check: func(c *Config) bool {
+				p := c.DNS.Lines[0].V6.Thresholds.MaxLatencyMs
+				return p != nil && *p == 300 && c.DNS.Lines[0].V4.Thresholds.MaxLatencyMs == nil
+			},
+		},
+		{
+			name:  "_FILE suffix reads the value from a file",
+			env:   []string{"CFST_DNS_ZONE_ID_FILE=" + zoneFile},
+			check: func(c *Config) bool { return c.DNS.ZoneId == "zone-from-file" },
+		},
+		{
+			// token_file 本身是配置字段，CFST_GIST_TOKEN_FILE 设置该字段而不是读取文件
+			name:  "_FILE matching a real field sets that field",
+			env:   []string{"CFST_GIST_TOKEN_FILE=/run/secrets/token"},
+			check: func(c *Config) bool { return c.Gist.TokenFile == "/run/secrets/token" && c.Gist.Token == "" },
+		},
+		{
+			name:  "unknown names are ignored",
+			env:   []string{"CFST_CONTROLLER_SERVICE_HOST=10.0.0.1", "CFST_DNS_LINES_5_CAP=1", "OTHER_DNS_ZONE_ID=x"},
+			check: func(c *Config) bool { return c.DNS.ZoneId == "" && len(c.DNS.Lines) == 2 },
+		},
+		{
+			name:         "invalid values are reported by variable name",
+			env:          []string{"CFST_THRESHOLDS_MAX_LATENCY_MS=fast", "CFST_HISTORY_ENABLED=maybe", "CFST_DNS_TTL=60"},
+			check:        func(c *Config) bool { return c.DNS.TTL == 60 },
+			wantProblems: []string{"CFST_HISTORY_ENABLED", "CFST_THRESHOLDS_MAX_LATENCY_MS"},
+		},
+		{
+			name:         "missing file",
+			env:          []string{"CFST_DNS_ZONE_ID_FILE=" + filepath.Join(t.TempDir(), "missing")},
+			check:        func(c *Config) bool { return c.DNS.ZoneId == "" },
+			wantProblems: []string{"CFST_DNS_ZONE_ID_FILE"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{}
+			cfg.DNS.Lines = []Line{{Operator: "ct", Cap: 2}, {Operator: "cu", Cap: 2}}
+
+			got := problemPaths(t, applyEnvOverrides(cfg, tt.env))
+			if !slices.Equal(got, tt.wantProblems) {
+				t.Errorf("problems = %v, want %v", got, tt.wantProblems)
+			}
+			if !tt.check(cfg) {
+				t.Errorf("override not applied as expected: %+v", cfg)
+			}
+		})
+	}
+}
+
+func TestResolveSecrets(t *testing.T) {
+	tokenFile := writeFile(t, "token", "  token-from-file\n")
+	t.Setenv("TEST_GIST_TOKEN", "token-from-env")
+
+	tests := []struct {
+		name         string
+		token        string
+		tokenFile    string
+		wantToken    string
+		wantProblems []string
+	}{
+		{
+			name:      "plain value",
+			token:     "literal",
+			wantToken: "literal",
+		},
+		{
+			name:      "expands variables",
+			token:     "${TEST_GIST_TOKEN}",
+			wantToken: "token-from-env",
+		},
+		{
+			name:      "file takes precedence",
+			token:     "${TEST_GIST_TOKEN}",
+			tokenFile: tokenFile,
+			wantToken: "token-from-file",
+		},
+		{
+			name:         "missing file",
+			token:        "literal",
+			tokenFile:    filepath.Join(t.TempDir(), "missing"),
+			wantToken:    "literal",
+			wantProblems: []string{"gist.token_file"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{}
+			cfg.Gist.Token = tt.token
+			cfg.Gist.TokenFile = tt.tokenFile
+
+			got := problemPaths(t, resolveSecrets(cfg))
+			if !slices.Equal(got, tt.wantProblems) {
+				t.Errorf("problems = %v, want %v", got, tt.wantProblems)
+			}
+			if cfg.Gist.Token != tt.wantToken {
+				t.Errorf("token = %q, want %q", cfg.Gist.Token, tt.wantToken)
+			}
+		})
+	}
+}
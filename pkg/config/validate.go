@@ -0,0 +1,489 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"controller/pkg/models"
+	dnsRegion "github.com/huaweicloud/huaweicloud-sdk-go-v3/services/dns/v2/region"
+	"github.com/robfig/cron/v3"
+)
+
+// validOperatorCode 限制运营商代码的字符: 代码会出现在线路 key (cu-v4) 和文件名中
+var validOperatorCode = regexp.MustCompile(`^[a-z0-9]+$`)
+
+// huaweiMinTTL 是华为云 DNS 允许的最小 TTL
+const huaweiMinTTL = 1
+
+// Problem 是一条配置错误，Path 为其在 YAML 中的路径 (如 dns.lines[1].cap)
+type Problem struct {
+	Path    string
+	Message string
+}
+
+func (p Problem) String() string {
+	return p.Path + ": " + p.Message
+}
+
+// ValidationError 汇总了一次校验发现的全部问题
+type ValidationError struct {
+	Problems []Problem
+}
+
+func (e *ValidationError) Error() string {
+	lines := make([]string, 0, len(e.Problems))
+	for _, p := range e.Problems {
+		lines = append(lines, "  - "+p.String())
+	}
+	return fmt.Sprintf("config has %d problem(s):\n%s", len(e.Problems), strings.Join(lines, "\n"))
+}
+
+type problems []Problem
+
+func (ps *problems) add(path, format string, args ...interface{}) {
+	*ps = append(*ps, Problem{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// Validate 检查配置的语义错误，返回包含所有问题的 *ValidationError；配置无误时返回 nil
+func (c *Config) Validate() error {
+	var ps problems
+
+	if c.Cron.Spec == "" {
+		ps.add("cron.spec", "must be set")
+	} else if _, err := cron.ParseStandard(c.Cron.Spec); err != nil {
+		ps.add("cron.spec", "invalid cron expression: %v", err)
+	}
+
+	if len(c.Gist.DeviceGists) == 0 {
+		ps.add("gist.device_gists", "at least one device gist is required")
+	}
+	for i, id := range c.Gist.DeviceGists {
+		if strings.TrimSpace(id) == "" {
+			ps.add(fmt.Sprintf("gist.device_gists[%d]", i), "must not be empty")
+		}
+	}
+	if c.Gist.GistUpdateCheckMinutes < 0 {
+		ps.add("gist.gist_update_check_minutes", "must not be negative")
+	}
+
+	c.validateLines(&ps)
+
+	if c.Huawei.Enabled {
+		if c.Huawei.Region == "" {
+			ps.add("huawei.region", "must be set when huawei is enabled")
+		} else if _, err := dnsRegion.SafeValueOf(c.Huawei.Region); err != nil {
+			ps.add("huawei.region", "unsupported region %q", c.Huawei.Region)
+		}
+		if c.Huawei.ProjectID == "" {
+			ps.add("huawei.project_id", "must be set when huawei is enabled")
+		}
+		if c.Huawei.AccessKey == "" {
+			ps.add("huawei.access_key", "must be set when huawei is enabled")
+		}
+		if c.Huawei.SecretKey == "" {
+			ps.add("huawei.secret_key", "must be set when huawei is enabled")
+		}
+	}
+
+	if c.Scoring.EWMA.Enabled && !c.History.Enabled {
+		ps.add("scoring.ewma.enabled", "requires history.enabled")
+	}
+	switch c.Publish.Mode {
+	case "", PublishAlways, PublishOnChange, PublishNever:
+	default:
+		ps.add("publish.mode", "unknown mode %q (want always, on_change or never)", c.Publish.Mode)
+	}
+	switch c.Publish.Prune {
+	case "", PruneExpire, PruneDelete, PruneOff:
+	default:
+		ps.add("publish.prune", "unknown value %q (want expire, delete or off)", c.Publish.Prune)
+	}
+	if c.Publish.HistorySize < 0 {
+		ps.add("publish.history_size", "must not be negative")
+	}
+	c.validateOutputs(&ps)
+	if c.History.RetentionDays < 0 {
+		ps.add("history.retention_days", "must not be negative")
+	}
+
+	if c.Probe.Port < 0 || c.Probe.Port > 65535 {
+		ps.add("probe.port", "must be between 1 and 65535")
+	}
+	if c.Probe.TimeoutSeconds < 0 {
+		ps.add("probe.timeout_seconds", "must not be negative")
+	}
+	if c.Probe.Concurrency < 0 {
+		ps.add("probe.concurrency", "must not be negative")
+	}
+	if c.Monitor.IntervalSeconds < 0 {
+		ps.add("monitor.interval_seconds", "must not be negative")
+	}
+	if c.Monitor.FailureThreshold < 0 {
+		ps.add("monitor.failure_threshold", "must not be negative")
+	}
+	if c.API.Enabled {
+		if c.API.Listen == "" {
+			ps.add("api.listen", "must be set when the HTTP API is enabled")
+		}
+		if c.API.Token == "" {
+			ps.add("api.token", "must be set when the HTTP API is enabled")
+		}
+	}
+	if c.Notify.MinIntervalMinutes < 0 {
+		ps.add("notify.min_interval_minutes", "must not be negative")
+	}
+
+	switch c.Run.Overlap {
+	case "", OverlapSkip, OverlapQueue:
+	default:
+		ps.add("run.overlap", "unknown mode %q (want skip or queue)", c.Run.Overlap)
+	}
+	if c.Run.TimeoutMinutes < 0 {
+		ps.add("run.timeout_minutes", "must not be negative")
+	}
+	if c.Run.ShutdownGraceSeconds < 0 {
+		ps.add("run.shutdown_grace_seconds", "must not be negative")
+	}
+	if c.Leader.Enabled {
+		switch c.Leader.Backend {
+		case LeaderBackendFile:
+		case LeaderBackendKubernetes:
+			if c.Leader.Kubernetes.Name == "" {
+				ps.add("leader.kubernetes.name", "must be set when backend is %q", LeaderBackendKubernetes)
+			}
+		case LeaderBackendGist:
+			if c.Leader.Gist.GistID == "" && c.Gist.ResultGistID == "" {
+				ps.add("leader.gist.gist_id", "must be set (or gist.result_gist_id) when backend is %q", LeaderBackendGist)
+			}
+		default:
+			ps.add("leader.backend", "unknown backend %q (want file, kubernetes or gist)", c.Leader.Backend)
+		}
+		if c.Leader.LeaseSeconds < 0 {
+			ps.add("leader.lease_seconds", "must not be negative")
+		}
+		if c.Leader.RenewSeconds < 0 {
+			ps.add("leader.renew_seconds", "must not be negative")
+		} else if c.Leader.RenewInterval() >= c.Leader.LeaseDuration() {
+			ps.add("leader.renew_seconds", "must be shorter than lease_seconds")
+		} else if c.Leader.Backend == LeaderBackendGist && c.Leader.RenewInterval() < GistLeaderMinRenew {
+			ps.add("leader.renew_seconds", "must be at least %d for backend %q, every renewal is a Gist revision",
+				int(GistLeaderMinRenew.Seconds()), LeaderBackendGist)
+		}
+	}
+	if c.Reload.IntervalSeconds < 0 {
+		ps.add("reload.interval_seconds", "must not be negative")
+	}
+
+	switch strings.ToLower(c.Log.Level) {
+	case "", "debug", "info", "warn", "warning", "error":
+	default:
+		ps.add("log.level", "unknown level %q (want debug, info, warn or error)", c.Log.Level)
+	}
+	switch c.Log.Format {
+	case "", "text", "json":
+	default:
+		ps.add("log.format", "unknown format %q (want text or json)", c.Log.Format)
+	}
+
+	if len(ps) > 0 {
+		return &ValidationError{Problems: ps}
+	}
+	return nil
+}
+
+// ValidateLines 只检查线路相关的配置 (operators、dns、全局阈值和打分参数)，
+// 供只展示线路的命令使用，不要求 DNS 服务商密钥等运行时才需要的配置
+func (c *Config) ValidateLines() error {
+	var ps problems
+	c.validateLines(&ps)
+	if len(ps) > 0 {
+		return &ValidationError{Problems: ps}
+	}
+	return nil
+}
+
+func (c *Config) validateLines(ps *problems) {
+	c.validateOperators(ps)
+	c.validateDNS(ps)
+	validateThresholds(ps, "thresholds", c.Thresholds.MaxLatencyMs, c.Thresholds.MinDownloadMbps, c.Thresholds.MaxLossPct)
+	if c.Scoring.EWMA.HalfLifeMinutes < 0 {
+		ps.add("scoring.ewma.half_life_minutes", "must not be negative")
+	}
+	if c.Scoring.EWMA.LookbackMinutes < 0 {
+		ps.add("scoring.ewma.lookback_minutes", "must not be negative")
+	}
+}
+
+func (c *Config) validateDNS(ps *problems) {
+	if len(c.DNS.Groups) == 0 {
+		c.validateGroup(ps, "dns", c.DNS.RecordGroups()[0])
+		return
+	}
+	if c.DNS.legacy() {
+		ps.add("dns", "zone_id/domain/subdomain/ttl/lines cannot be combined with groups, move them into a group")
+	}
+	seen := make(map[string]int)
+	for i, g := range c.DNS.Groups {
+		path := fmt.Sprintf("dns.groups[%d]", i)
+		switch {
+		case g.ID == "":
+			ps.add(path+".id", "must be set")
+		case !validGroupID.MatchString(g.ID):
+			ps.add(path+".id", "%q may only contain letters, digits, '_' and '-'", g.ID)
+		default:
+			if j, dup := seen[g.ID]; dup {
+				ps.add(path+".id", "duplicate group %q, already defined at dns.groups[%d]", g.ID, j)
+			} else {
+				seen[g.ID] = i
+			}
+		}
+		c.validateGroup(ps, path, g)
+	}
+}
+
+var validGroupID = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func (c *Config) validateGroup(ps *problems, path string, g RecordGroup) {
+	if g.Domain == "" {
+		ps.add(path+".domain", "must be set")
+	}
+	if g.Subdomain == "" {
+		ps.add(path+".subdomain", "must be set (use \"@\" for the zone apex)")
+	}
+	switch g.ProviderOrDefault() {
+	case ProviderHuawei:
+		if c.Huawei.Enabled && g.ZoneId == "" {
+			ps.add(path+".zone_id", "must be set when huawei is enabled")
+		}
+		if g.TTL < huaweiMinTTL {
+			ps.add(path+".ttl", "must be at least %d, got %d", huaweiMinTTL, g.TTL)
+		}
+	default:
+		ps.add(path+".provider", "unknown provider %q (want one of %s)", g.Provider, strings.Join(KnownProviders, ", "))
+	}
+	if len(g.Lines) == 0 {
+		ps.add(path+".lines", "at least one line is required")
+	}
+
+	seen := make(map[string]int)
+	for i, l := range g.Lines {
+		linePath := fmt.Sprintf("%s.lines[%d]", path, i)
+		switch {
+		case l.Operator == "":
+			ps.add(linePath+".operator", "must be set")
+		case !c.isKnownOperator(l.Operator):
+			ps.add(linePath+".operator", "unknown operator %q (want one of %s)", l.Operator, strings.Join(c.OperatorCodes(), ", "))
+		default:
+			if j, dup := seen[l.Operator]; dup {
+				ps.add(linePath+".operator", "duplicate line %q, already defined at %s.lines[%d]", l.Operator, path, j)
+			} else {
+				seen[l.Operator] = i
+			}
+		}
+		c.validateLine(ps, linePath, l)
+	}
+}
+
+func (c *Config) validateLine(ps *problems, path string, l Line) {
+	if c.Huawei.Enabled && l.ARecordsetID == "" && l.AAAARecordsetID == "" {
+		ps.add(path, "at least one of a_recordset_id or aaaa_recordset_id must be set")
+	}
+
+	if l.Cap < 0 {
+		ps.add(path+".cap", "must not be negative")
+	}
+	if l.CapV4 < 0 {
+		ps.add(path+".cap_v4", "must not be negative")
+	}
+	if l.CapV6 < 0 {
+		ps.add(path+".cap_v6", "must not be negative")
+	}
+	if l.Cap == 0 && (l.CapV4 == 0 || l.CapV6 == 0) {
+		ps.add(path+".cap", "must be greater than 0 unless both cap_v4 and cap_v6 are set")
+	}
+	if l.MinActive < 0 {
+		ps.add(path+".min_active", "must not be negative")
+	}
+	for _, ver := range []string{"v4", "v6"} {
+		if capN := l.CapFor(ver); capN > 0 && l.MinActive > capN {
+			ps.add(path+".min_active", "%d exceeds the %s cap of %d", l.MinActive, ver, capN)
+		}
+	}
+	if l.MaxScoreGap < 0 {
+		ps.add(path+".max_score_gap", "must not be negative")
+	}
+
+	for _, ver := range []string{"v4", "v6"} {
+		th := l.V4.Thresholds
+		if ver == "v6" {
+			th = l.V6.Thresholds
+		}
+		validateThresholds(ps, fmt.Sprintf("%s.%s.thresholds", path, ver),
+			derefInt(th.MaxLatencyMs), derefFloat(th.MinDownloadMbps), derefFloat(th.MaxLossPct))
+	}
+
+	l.Fallback.validate(ps, path+".fallback", c.isKnownOperator)
+}
+
+func (f Fallback) validate(ps *problems, path string, isKnownOperator func(string) bool) {
+	switch f.Policy {
+	case "", FallbackKeep, FallbackRelax:
+	case FallbackBorrow:
+		if len(f.BorrowFrom) == 0 {
+			ps.add(path+".borrow_from", "must list at least one operator when policy is %q", FallbackBorrow)
+		}
+	case FallbackStatic:
+		if len(f.StaticV4) == 0 && len(f.StaticV6) == 0 {
+			ps.add(path, "static_v4 or static_v6 must be set when policy is %q", FallbackStatic)
+		}
+	default:
+		ps.add(path+".policy", "unknown policy %q (want keep, relax, borrow or static)", f.Policy)
+	}
+	if f.RelaxSteps < 0 {
+		ps.add(path+".relax_steps", "must not be negative")
+	}
+	if f.RelaxFactor < 0 {
+		ps.add(path+".relax_factor", "must not be negative")
+	}
+	for i, op := range f.BorrowFrom {
+		if !isKnownOperator(op) {
+			ps.add(fmt.Sprintf("%s.borrow_from[%d]", path, i), "unknown operator %q", op)
+		}
+	}
+}
+
+func validateThresholds(ps *problems, path string, maxLatencyMs int, minDownloadMbps, maxLossPct float64) {
+	if maxLatencyMs < 0 {
+		ps.add(path+".max_latency_ms", "must not be negative")
+	}
+	if minDownloadMbps < 0 {
+		ps.add(path+".min_download_mbps", "must not be negative")
+	}
+	if maxLossPct < 0 || maxLossPct > 100 {
+		ps.add(path+".max_loss_pct", "must be between 0 and 100")
+	}
+}
+
+func (c *Config) isKnownOperator(code string) bool {
+	_, ok := c.Operator(code)
+	return ok
+}
+
+func (c *Config) validateOperators(ps *problems) {
+	seen := make(map[string]int)
+	for i, op := range c.Operators {
+		path := fmt.Sprintf("operators[%d]", i)
+		switch {
+		case op.Code == "":
+			ps.add(path+".code", "must be set")
+		case !validOperatorCode.MatchString(op.Code):
+			ps.add(path+".code", "%q may only contain lowercase letters and digits", op.Code)
+		default:
+			if j, dup := seen[op.Code]; dup {
+				ps.add(path+".code", "duplicate operator %q, already defined at operators[%d]", op.Code, j)
+			} else {
+				seen[op.Code] = i
+			}
+		}
+		for _, provider := range slices.Sorted(maps.Keys(op.ProviderLines)) {
+			if !isKnownProvider(provider) {
+				ps.add(path+".provider_lines", "unknown provider %q (want one of %s)", provider, strings.Join(KnownProviders, ", "))
+			}
+		}
+	}
+}
+
+func isKnownProvider(name string) bool {
+	for _, p := range KnownProviders {
+		if p == name {
+			return true
+		}
+	}
+	return false
+}
+
+func derefInt(p *int) int {
+	if p == nil {
+		return 0
+	}
+	return *p
+}
+
+func derefFloat(p *float64) float64 {
+	if p == nil {
+		return 0
+	}
+	return *p
+}
+
+func (c *Config) validateOutputs(ps *problems) {
+	lineKeys := make(map[string]bool)
+	for _, g := range c.DNS.RecordGroups() {
+		for _, l := range g.Lines {
+			for _, ver := range []string{"v4", "v6"} {
+				lineKeys[models.LineKey(g.ID, l.Operator, ver)] = true
+			}
+		}
+	}
+
+	files := make(map[string]int)
+	for i, o := range c.Publish.Outputs {
+		path := fmt.Sprintf("publish.outputs[%d]", i)
+		switch o.Format {
+		case OutputIPTxt, OutputCFST, OutputClash, OutputSingBox, OutputV2rayN:
+		default:
+			ps.add(path+".format", "unknown format %q (want iptxt, cfst, clash, singbox or v2rayn)", o.Format)
+			continue
+		}
+		file := o.DefaultFile()
+		if strings.Contains(file, "/") {
+			ps.add(path+".file", "must not contain '/'")
+		}
+		if j, dup := files[file]; dup {
+			ps.add(path+".file", "%q is already used by publish.outputs[%d]", file, j)
+		} else {
+			files[file] = i
+		}
+		for j, key := range o.Lines {
+			if !lineKeys[key] {
+				ps.add(fmt.Sprintf("%s.lines[%d]", path, j), "unknown line %q", key)
+			}
+		}
+		switch o.Pool {
+		case "", PoolCandidates, PoolActive:
+		default:
+			ps.add(path+".pool", "unknown pool %q (want candidates or active)", o.Pool)
+		}
+		if o.NeedsProxy() {
+			o.Proxy.validate(ps, path+".proxy")
+		}
+	}
+}
+
+func (p Proxy) validate(ps *problems, path string) {
+	switch p.Type {
+	case ProxyVLESS, ProxyVMess:
+		if p.UUID == "" {
+			ps.add(path+".uuid", "must be set for %s", p.Type)
+		}
+	case ProxyTrojan:
+		if p.Password == "" {
+			ps.add(path+".password", "must be set for trojan")
+		}
+	case "":
+		ps.add(path+".type", "must be set")
+	default:
+		ps.add(path+".type", "unknown type %q (want vless, vmess or trojan)", p.Type)
+	}
+	if p.Port < 0 || p.Port > 65535 {
+		ps.add(path+".port", "must be between 1 and 65535")
+	}
+	switch p.Network {
+	case "", "tcp", "ws", "grpc":
+	default:
+		ps.add(path+".network", "unknown network %q (want tcp, ws or grpc)", p.Network)
+	}
+}
@@ -0,0 +1,173 @@
+package config
+
+import (
+	"slices"
+	"testing"
+)
+
+// validConfig 返回一份能通过校验的最小配置
+func validConfig() *Config {
+	c := &Config{}
+	c.Cron.Spec = "*/15 * * * *"
+	c.Gist.DeviceGists = []string{"g1"}
+	c.DNS.Domain = "example.com"
+	c.DNS.Subdomain = "cf"
+	c.DNS.TTL = 300
+	c.DNS.Lines = []Line{{Operator: "ct", Cap: 2}, {Operator: "cu", Cap: 2}}
+	c.Thresholds = Thresholds{MaxLatencyMs: 150, MinDownloadMbps: 10, MaxLossPct: 5}
+	return c
+}
+
+func TestValidate(t *testing.T) {
+	negative := -1
+
+	tests := []struct {
+		name         string
+		mutate       func(*Config)
+		wantProblems []string
+	}{
+		{
+			name:   "valid",
+			mutate: func(*Config) {},
+		},
+		{
+			name:         "invalid cron and empty device gist",
+			mutate:       func(c *Config) { c.Cron.Spec = "every day"; c.Gist.DeviceGists = []string{"g1", " "} },
+			wantProblems: []string{"cron.spec", "gist.device_gists[1]"},
+		},
+		{
+			name: "unknown and duplicate operators",
+			mutate: func(c *Config) {
+				c.DNS.Lines = append(c.DNS.Lines, Line{Operator: "xx", Cap: 1}, Line{Operator: "ct", Cap: 1})
+			},
+			wantProblems: []string{"dns.lines[2].operator", "dns.lines[3].operator"},
+		},
+		{
+			name:         "custom operators",
+			mutate:       func(c *Config) { c.Operators = []Operator{{Code: "ct"}, {Code: "CU"}, {Code: "ct"}} },
+			wantProblems: []string{"operators[1].code", "operators[2].code", "dns.lines[1].operator"},
+		},
+		{
+			name:         "cap 0 requires both per-version caps",
+			mutate:       func(c *Config) { c.DNS.Lines[0] = Line{Operator: "ct", CapV4: 2} },
+			wantProblems: []string{"dns.lines[0].cap"},
+		},
+		{
+			name:         "min_active exceeds the v6 cap",
+			mutate:       func(c *Config) { c.DNS.Lines[0] = Line{Operator: "ct", Cap: 3, CapV6: 1, MinActive: 2} },
+			wantProblems: []string{"dns.lines[0].min_active"},
+		},
+		{
+			name:         "negative max_score_gap",
+			mutate:       func(c *Config) { c.DNS.Lines[1].MaxScoreGap = -0.1 },
+			wantProblems: []string{"dns.lines[1].max_score_gap"},
+		},
+		{
+			name: "invalid overrides",
+			mutate: func(c *Config) {
+				c.DNS.Lines[0].V6.Thresholds.MaxLatencyMs = &negative
+				c.Thresholds.MaxLossPct = 101
+			},
+			wantProblems: []string{"dns.lines[0].v6.thresholds.max_latency_ms", "thresholds.max_loss_pct"},
+		},
+		{
+			name: "fallback policies",
+			mutate: func(c *Config) {
+				c.DNS.Lines[0].Fallback = Fallback{Policy: FallbackBorrow}
+				c.DNS.Lines[1].Fallback = Fallback{Policy: FallbackRelax, BorrowFrom: []string{"xx"}, RelaxSteps: -1}
+			},
+			wantProblems: []string{"dns.lines[0].fallback.borrow_from", "dns.lines[1].fallback.relax_steps", "dns.lines[1].fallback.borrow_from[0]"},
+		},
+		{
+			name:         "unknown fallback policy",
+			mutate:       func(c *Config) { c.DNS.Lines[0].Fallback = Fallback{Policy: "random"} },
+			wantProblems: []string{"dns.lines[0].fallback.policy"},
+		},
+		{
+			name: "groups cannot be combined with legacy fields",
+			mutate: func(c *Config) {
+				g := RecordGroup{ID: "cdn", Domain: "example.com", Subdomain: "cdn", TTL: 300, Lines: []Line{{Operator: "ct", Cap: 1}}}
+				c.DNS.Groups = []RecordGroup{g, g}
+			},
+			wantProblems: []string{"dns", "dns.groups[1].id"},
+		},
+		{
+			name: "huawei requires keys, zone and record sets",
+			mutate: func(c *Config) {
+				c.Huawei.Enabled = true
+				c.Huawei.Region = "cn-north-4"
+				c.Huawei.ProjectID = "p"
+				c.DNS.Lines[1].AAAARecordsetID = "rs"
+			},
+			wantProblems: []string{"dns.zone_id", "dns.lines[0]", "huawei.access_key", "huawei.secret_key"},
+		},
+		{
+			name:         "ewma requires history",
+			mutate:       func(c *Config) { c.Scoring.EWMA.Enabled = true },
+			wantProblems: []string{"scoring.ewma.enabled"},
+		},
+		{
+			name: "outputs",
+			mutate: func(c *Config) {
+				c.Publish.Outputs = []Output{
+					{Format: OutputIPTxt, Lines: []string{"cu-v4", "cm-v4"}},
+					{Format: OutputIPTxt},
+					{Format: OutputClash, File: "a/b.yaml"},
+				}
+			},
+			wantProblems: []string{"publish.outputs[0].lines[1]", "publish.outputs[1].file", "publish.outputs[2].file", "publish.outputs[2].proxy.type"},
+		},
+		{
+			name: "leader renew must be shorter than the lease",
+			mutate: func(c *Config) {
+				c.Leader = Leader{Enabled: true, Backend: LeaderBackendFile, LeaseSeconds: 30, RenewSeconds: 30}
+			},
+			wantProblems: []string{"leader.renew_seconds"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := validConfig()
+			tt.mutate(c)
+			got := problemPaths(t, c.Validate())
+			if !slices.Equal(got, tt.wantProblems) {
+				t.Errorf("problems = %v, want %v", got, tt.wantProblems)
+			}
+		})
+	}
+}
+
+func TestValidateLines(t *testing.T) {
+	tests := []struct {
+		name         string
+		mutate       func(*Config)
+		wantProblems []string
+	}{
+		{
+			// 只展示线路时不要求 cron 和 DNS 服务商密钥
+			name: "ignores runtime settings",
+			mutate: func(c *Config) {
+				c.Cron.Spec = ""
+				c.Huawei = Huawei{Enabled: true}
+				c.DNS.Lines[0].ARecordsetID = "rs-ct"
+				c.DNS.Lines[1].ARecordsetID = "rs-cu"
+				c.DNS.ZoneId = "z"
+			},
+		},
+		{
+			name:         "reports line problems",
+			mutate:       func(c *Config) { c.DNS.Lines[1].Cap = -1; c.Scoring.EWMA.HalfLifeMinutes = -5 },
+			wantProblems: []string{"dns.lines[1].cap", "scoring.ewma.half_life_minutes"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := validConfig()
+			tt.mutate(c)
+			got := problemPaths(t, c.ValidateLines())
+			if !slices.Equal(got, tt.wantProblems) {
+				t.Errorf("problems = %v, want %v", got, tt.wantProblems)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"os"
+	"time"
+)
+
+// Watch 每隔 interval 检查一次配置文件，内容变化时调用 onChange，直到 ctx 被取消。
+// 比较的是文件内容而不是修改时间，以兼容 Kubernetes ConfigMap 通过符号链接替换文件的方式。
+func Watch(ctx context.Context, path string, interval time.Duration, onChange func()) {
+	last, _ := fileHash(path)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		h, err := fileHash(path)
+		if err != nil || h == last {
+			continue
+		}
+		last = h
+		onChange()
+	}
+}
+
+func fileHash(path string) ([sha256.Size]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	return sha256.Sum256(data), nil
+}
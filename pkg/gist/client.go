@@ -4,15 +4,19 @@ package gist
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"maps"
 	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
+	"controller/pkg/logging"
 	"controller/pkg/models"
 )
 
@@ -22,6 +26,28 @@ type Client struct {
 	token       string
 	proxyPrefix string
 	httpClient  *http.Client
+
+	// [新增] 最近一次 GitHub API 响应中的 X-RateLimit-Remaining, -1 表示未知
+	rateLimitRemaining int
+	logger             *slog.Logger
+
+	// [新增] 设备 Gist 元数据缓存，配合 ETag 发起条件请求
+	cache map[string]GistCache
+}
+
+// GistCache 是某个设备 Gist 最近一次读取到的元数据。
+// 请求时带上 If-None-Match，GitHub 返回 304 (不计入 API 限额) 时直接使用缓存
+type GistCache struct {
+	ETag      string            `json:"etag"`
+	UpdatedAt time.Time         `json:"updated_at"`
+	Files     map[string]string `json:"files"` // 文件名 -> raw_url
+}
+
+// [新增] DeviceGist 是一次读取设备 Gist 的结果
+type DeviceGist struct {
+	Results   []models.DeviceResult
+	UpdatedAt time.Time // Gist 的 updated_at，即这批测速结果的上传时间
+	Changed   bool      // 与缓存中上次读取的内容相比有变化 (没有缓存时视为有变化)
 }
 
 func NewClient(token, proxyPrefix string) *Client {
@@ -31,15 +57,47 @@ func NewClient(token, proxyPrefix string) *Client {
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
+		rateLimitRemaining: -1,
+		logger:             slog.Default(),
+		cache:              make(map[string]GistCache),
 	}
 }
 
+// WithCache 设置上一次保存的设备 Gist 元数据缓存
+func (c *Client) WithCache(cache map[string]GistCache) *Client {
+	c.cache = maps.Clone(cache)
+	if c.cache == nil {
+		c.cache = make(map[string]GistCache)
+	}
+	return c
+}
+
+// Cache 返回当前的设备 Gist 元数据缓存，供调用方保存
+func (c *Client) Cache() map[string]GistCache {
+	return maps.Clone(c.cache)
+}
+
+// WithLogger 设置带有运行上下文字段 (run_id、phase 等) 的 logger
+func (c *Client) WithLogger(l *slog.Logger) *Client {
+	c.logger = l
+	return c
+}
+
 func (c *Client) doRequestWithRetry(req *http.Request, maxRetries int) (*http.Response, error) {
 	req.Header.Set("User-Agent", browserUserAgent)
 	var err error
 	var resp *http.Response
 	for i := 0; i < maxRetries; i++ {
+		// 重试时重新取得请求体，避免发送已被读空的 body
+		if i > 0 && req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
 		resp, err = c.httpClient.Do(req)
+		if resp != nil {
+			c.recordRateLimit(resp)
+		}
 		if err == nil && resp.StatusCode < 500 {
 			return resp, nil
 		}
@@ -47,67 +105,107 @@ func (c *Client) doRequestWithRetry(req *http.Request, maxRetries int) (*http.Re
 		if resp != nil {
 			status = resp.Status
 		}
-		log.Printf("[warn] Request to %s failed (attempt %d/%d): %v, status: %s", req.URL, i+1, maxRetries, err, status)
+		c.logger.Warn("gist request failed", "method", req.Method, "url", req.URL.String(),
+			"attempt", i+1, "max_attempts", maxRetries, "status", status, "error", err)
 		if resp != nil {
 			resp.Body.Close()
 		}
-		time.Sleep(time.Second * time.Duration(2*i))
+		// [新增] 运行被取消或超时后不再重试
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(time.Second * time.Duration(2*i)):
+		}
 	}
 	return resp, err
 }
 
 // [修改] 参数 maxAgeMinutes int
-func (c *Client) FetchDeviceResults(gistID string, maxAgeMinutes int) ([]models.DeviceResult, error) {
-	log.Printf("[info] ---> Fetching data from Gist ID: %s", gistID)
+// [修改] 请求随 ctx 取消
+// [新增] 只读取 operators 中运营商的结果文件
+// [修改] 返回 DeviceGist，调用方据此只把内容有变化的 Gist 写入历史库
+func (c *Client) FetchDeviceResults(ctx context.Context, gistID string, maxAgeMinutes int, operators []string) (DeviceGist, error) {
+	logger := c.logger.With(logging.KeyGistID, gistID)
+	logger.Debug("fetching device gist")
 	apiRequestURL := c.buildURL("https://api.github.com/gists/" + gistID)
-	req, _ := http.NewRequest("GET", apiRequestURL, nil)
+	req, _ := http.NewRequestWithContext(ctx, "GET", apiRequestURL, nil)
 	req.Header.Set("Authorization", "token "+c.token)
+	cached, hasCache := c.cache[gistID]
+	if hasCache && cached.ETag != "" {
+		req.Header.Set("If-None-Match", cached.ETag)
+	}
 
 	resp, err := c.doRequestWithRetry(req, 3)
 	if err != nil || resp == nil {
-		return nil, fmt.Errorf("failed to fetch Gist metadata for %s: %v", gistID, err)
+		return DeviceGist{}, fmt.Errorf("failed to fetch Gist metadata for %s: %v", gistID, err)
 	}
 	defer resp.Body.Close()
 
-	bodyBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read Gist metadata response body for %s: %v", gistID, err)
-	}
+	var meta GistCache
+	changed := true
+	if resp.StatusCode == http.StatusNotModified && hasCache {
+		logger.Debug("device gist not modified, using cached metadata")
+		meta = cached
+		changed = false
+	} else {
+		bodyBytes, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return DeviceGist{}, fmt.Errorf("failed to read Gist metadata response body for %s: %v", gistID, err)
+		}
 
-	var gist struct {
-		Files map[string]struct {
-			Filename string `json:"filename"`
-			RawURL   string `json:"raw_url"`
-		} `json:"files"`
-		UpdatedAt time.Time `json:"updated_at"`
-	}
+		var gist struct {
+			Files map[string]struct {
+				Filename string `json:"filename"`
+				RawURL   string `json:"raw_url"`
+			} `json:"files"`
+			UpdatedAt time.Time `json:"updated_at"`
+		}
 
-	if err := json.Unmarshal(bodyBytes, &gist); err != nil {
-		return nil, fmt.Errorf("failed to decode Gist JSON for %s: %v", gistID, err)
+		if err := json.Unmarshal(bodyBytes, &gist); err != nil {
+			return DeviceGist{}, fmt.Errorf("failed to decode Gist JSON for %s: %v", gistID, err)
+		}
+		meta = GistCache{ETag: resp.Header.Get("ETag"), UpdatedAt: gist.UpdatedAt, Files: make(map[string]string, len(gist.Files))}
+		for _, f := range gist.Files {
+			meta.Files[f.Filename] = f.RawURL
+		}
+		changed = !hasCache || !meta.UpdatedAt.Equal(cached.UpdatedAt)
 	}
-	
+	// 没有 ETag 时同样缓存 updated_at，用于判断内容是否变化；有文件下载失败时不缓存，下次运行重新读取
+	complete := true
+	defer func() {
+		if complete && resp.StatusCode == http.StatusOK {
+			c.cache[gistID] = meta
+		}
+	}()
+
 	// [修改] 使用分钟进行时间比较
-	if maxAgeMinutes > 0 && time.Since(gist.UpdatedAt) > time.Duration(maxAgeMinutes)*time.Minute {
-		log.Printf("[info]     Gist %s is too old (updated at %v), skipping.", gistID, gist.UpdatedAt)
-		return nil, nil
+	if maxAgeMinutes > 0 && time.Since(meta.UpdatedAt) > time.Duration(maxAgeMinutes)*time.Minute {
+		logger.Info("device gist is too old, skipping", "updated_at", meta.UpdatedAt)
+		return DeviceGist{UpdatedAt: meta.UpdatedAt, Changed: changed}, nil
 	}
 
 	var allResults []models.DeviceResult
-	re := regexp.MustCompile(`results6?-(ct|cu|cm)-.*-(v4|v6)\.json`)
+	re := deviceFilePattern(operators)
 
-	for _, file := range gist.Files {
-		matches := re.FindStringSubmatch(strings.ToLower(file.Filename))
+	for filename, rawURL := range meta.Files {
+		matches := re.FindStringSubmatch(strings.ToLower(filename))
 		if len(matches) != 3 {
 			continue
 		}
 		operator, ipVersion := matches[1], matches[2]
-		log.Printf("[info]     + Processing matching file: %s (Operator: %s, IPVersion: %s)", file.Filename, operator, ipVersion)
+		fileLogger := logger.With("file", filename, logging.KeyOperator, operator, logging.KeyIPVersion, ipVersion)
+		fileLogger.Debug("processing matching file")
 
-		finalDownloadURL := c.buildURL(file.RawURL)
-		req, _ = http.NewRequest("GET", finalDownloadURL, nil)
+		finalDownloadURL := c.buildURL(rawURL)
+		req, _ = http.NewRequestWithContext(ctx, "GET", finalDownloadURL, nil)
 		dataResp, err := c.doRequestWithRetry(req, 3)
+		if ctx.Err() != nil {
+			complete = false
+			return DeviceGist{}, fmt.Errorf("fetching Gist %s aborted: %w", gistID, ctx.Err())
+		}
 		if err != nil || dataResp == nil {
-			log.Printf("[warn]       Failed to download content for %s. Skipping.", file.Filename)
+			fileLogger.Warn("failed to download file content, skipping", "error", err)
+			complete = false
 			continue
 		}
 		defer dataResp.Body.Close()
@@ -117,7 +215,7 @@ func (c *Client) FetchDeviceResults(gistID string, maxAgeMinutes int) ([]models.
 			Results []models.DeviceResult `json:"results"`
 		}
 		if err := json.Unmarshal(body, &data); err != nil {
-			log.Printf("[warn]       Failed to unmarshal content from %s. Error: %v", file.Filename, err)
+			fileLogger.Warn("failed to unmarshal file content, skipping", "error", err)
 			continue
 		}
 
@@ -127,23 +225,40 @@ func (c *Client) FetchDeviceResults(gistID string, maxAgeMinutes int) ([]models.
 		}
 
 		allResults = append(allResults, data.Results...)
-		log.Printf("[info]       Successfully processed %s, found %d valid results.", file.Filename, len(data.Results))
+		fileLogger.Debug("processed file", "results", len(data.Results))
+	}
+	logger.Info("fetched device gist", "results", len(allResults), "updated_at", meta.UpdatedAt, "changed", changed)
+	return DeviceGist{Results: allResults, UpdatedAt: meta.UpdatedAt, Changed: changed}, nil
+}
+
+// [新增] deviceFilePattern 按运营商代码生成设备结果文件名的匹配规则，
+// 如 results-ct-xxx-v4.json / results6-cu-xxx-v6.json
+func deviceFilePattern(operators []string) *regexp.Regexp {
+	quoted := make([]string, 0, len(operators))
+	for _, op := range operators {
+		quoted = append(quoted, regexp.QuoteMeta(strings.ToLower(op)))
 	}
-	log.Printf("[info] <--- Finished fetching Gist %s, total valid results gathered: %d", gistID, len(allResults))
-	return allResults, nil
+	return regexp.MustCompile(`results6?-(` + strings.Join(quoted, "|") + `)-.*-(v4|v6)\.json`)
 }
 
 // [重构] CreateOrUpdateResultGist 现在接收一个文件名到内容的映射
-func (c *Client) CreateOrUpdateResultGist(gistID string, filesToUpload map[string]string) (string, error) {
-	if len(filesToUpload) == 0 {
-		log.Println("[info] No files to upload to Gist. Skipping.")
+// [新增] deleteFiles 中的文件会被删除 (PATCH 时内容为 null)，创建新 Gist 时忽略
+func (c *Client) CreateOrUpdateResultGist(ctx context.Context, gistID string, filesToUpload map[string]string, deleteFiles []string) (string, error) {
+	if gistID == "" {
+		deleteFiles = nil
+	}
+	if len(filesToUpload) == 0 && len(deleteFiles) == 0 {
+		c.logger.Info("no files to upload to result gist, skipping")
 		return gistID, nil
 	}
 
-	fileMap := make(map[string]map[string]string)
+	fileMap := make(map[string]interface{})
 	for filename, content := range filesToUpload {
 		fileMap[filename] = map[string]string{"content": content}
 	}
+	for _, filename := range deleteFiles {
+		fileMap[filename] = nil
+	}
 
 	bodyMap := map[string]interface{}{
 		"description": "Multi-Net 优选 IP 结果 (分线路)",
@@ -156,14 +271,14 @@ func (c *Client) CreateOrUpdateResultGist(gistID string, filesToUpload map[strin
 	if gistID == "" {
 		method = "POST"
 		url = c.buildURL("https://api.github.com/gists")
-		log.Println("[info] ---> Creating new result Gist...")
+		c.logger.Info("creating new result gist")
 	} else {
 		method = "PATCH"
 		url = c.buildURL("https://api.github.com/gists/" + gistID)
-		log.Printf("[info] ---> Updating result Gist: %s", gistID)
+		c.logger.Info("updating result gist", logging.KeyGistID, gistID)
 	}
 
-	req, _ := http.NewRequest(method, url, bytes.NewReader(bodyBytes))
+	req, _ := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(bodyBytes))
 	req.Header.Set("Authorization", "token "+c.token)
 	req.Header.Set("Content-Type", "application/json")
 
@@ -172,20 +287,133 @@ func (c *Client) CreateOrUpdateResultGist(gistID string, filesToUpload map[strin
 		return "", fmt.Errorf("failed to create/update result Gist after retries: %v", err)
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("failed to %s result Gist: %s: %s", strings.ToLower(method), resp.Status, strings.TrimSpace(string(body)))
+	}
 
 	var respObj struct {
 		ID string `json:"id"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&respObj); err != nil {
-		return "", err
+		return "", fmt.Errorf("failed to decode result Gist response: %v", err)
 	}
-	log.Println("[info] <--- Successfully created/updated result Gist.")
+	if respObj.ID == "" {
+		return "", fmt.Errorf("result Gist response from %s has no id", method)
+	}
+	c.logger.Info("result gist written", logging.KeyGistID, respObj.ID, "files", len(filesToUpload), "deleted", len(deleteFiles))
 	return respObj.ID, nil
 }
 
+func (c *Client) recordRateLimit(resp *http.Response) {
+	if v := resp.Header.Get("X-RateLimit-Remaining"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			c.rateLimitRemaining = n
+		}
+	}
+}
+
+// RateLimitRemaining 返回最近一次观察到的 GitHub API 剩余请求数
+func (c *Client) RateLimitRemaining() (int, bool) {
+	return c.rateLimitRemaining, c.rateLimitRemaining >= 0
+}
+
 func (c *Client) buildURL(originalURL string) string {
 	if c.proxyPrefix == "" {
 		return originalURL
 	}
 	return c.proxyPrefix + originalURL
-}
\ No newline at end of file
+}
+
+// [新增] ReadFile 读取 Gist 中指定文件的内容，文件不存在时 found 为 false
+func (c *Client) ReadFile(ctx context.Context, gistID, filename string) (content string, found bool, err error) {
+	files, err := c.Files(ctx, gistID)
+	if err != nil {
+		return "", false, err
+	}
+	content, found = files[filename]
+	return content, found, nil
+}
+
+// [新增] Files 返回 Gist 中全部文件的名称和内容
+// [修改] GitHub 对较大的文件只在 content 中返回截断的内容 (truncated 为 true)，此时从 raw_url 下载完整内容
+func (c *Client) Files(ctx context.Context, gistID string) (map[string]string, error) {
+	req, _ := http.NewRequestWithContext(ctx, "GET", c.buildURL("https://api.github.com/gists/"+gistID), nil)
+	req.Header.Set("Authorization", "token "+c.token)
+	resp, err := c.doRequestWithRetry(req, 1)
+	if err != nil || resp == nil {
+		return nil, fmt.Errorf("failed to fetch Gist %s: %v", gistID, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch Gist %s: %s", gistID, resp.Status)
+	}
+
+	var gist struct {
+		Files map[string]struct {
+			Content   string `json:"content"`
+			Truncated bool   `json:"truncated"`
+			RawURL    string `json:"raw_url"`
+		} `json:"files"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&gist); err != nil {
+		return nil, fmt.Errorf("failed to decode Gist JSON for %s: %v", gistID, err)
+	}
+	files := make(map[string]string, len(gist.Files))
+	for name, f := range gist.Files {
+		if !f.Truncated {
+			files[name] = f.Content
+			continue
+		}
+		// 截断的内容不能当作完整文件使用，下载失败时返回错误，避免调用方据此覆盖原文件
+		content, err := c.downloadRaw(ctx, f.RawURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to download truncated file %s of Gist %s: %v", name, gistID, err)
+		}
+		c.logger.Debug("downloaded truncated gist file", logging.KeyGistID, gistID, "file", name, "bytes", len(content))
+		files[name] = content
+	}
+	return files, nil
+}
+
+// downloadRaw 下载 raw_url 指向的文件内容
+func (c *Client) downloadRaw(ctx context.Context, rawURL string) (string, error) {
+	req, _ := http.NewRequestWithContext(ctx, "GET", c.buildURL(rawURL), nil)
+	req.Header.Set("Authorization", "token "+c.token)
+	resp, err := c.doRequestWithRetry(req, 1)
+	if err != nil || resp == nil {
+		return "", fmt.Errorf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read body: %v", err)
+	}
+	return string(body), nil
+}
+
+// [新增] UpdateFiles 只更新 Gist 中的指定文件，其余文件保持不变
+func (c *Client) UpdateFiles(ctx context.Context, gistID string, files map[string]string) error {
+	fileMap := make(map[string]map[string]string, len(files))
+	for filename, content := range files {
+		fileMap[filename] = map[string]string{"content": content}
+	}
+	bodyBytes, _ := json.Marshal(map[string]interface{}{"files": fileMap})
+
+	req, _ := http.NewRequestWithContext(ctx, "PATCH", c.buildURL("https://api.github.com/gists/"+gistID), bytes.NewReader(bodyBytes))
+	req.Header.Set("Authorization", "token "+c.token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.doRequestWithRetry(req, 1)
+	if err != nil || resp == nil {
+		return fmt.Errorf("failed to update Gist %s: %v", gistID, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to update Gist %s: %s", gistID, resp.Status)
+	}
+	c.logger.Debug("gist files updated", logging.KeyGistID, gistID, "files", len(files))
+	return nil
+}
@@ -0,0 +1,23 @@
+//go:build !unix
+
+package leader
+
+import (
+	"context"
+	"errors"
+)
+
+// FileLock 在非 unix 平台上不可用
+type FileLock struct{}
+
+func NewFileLock(path string) *FileLock {
+	return &FileLock{}
+}
+
+func (l *FileLock) TryAcquire(ctx context.Context) (bool, error) {
+	return false, errors.New("file lock backend is only supported on unix")
+}
+
+func (l *FileLock) Release(ctx context.Context) error {
+	return nil
+}
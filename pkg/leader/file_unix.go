@@ -0,0 +1,63 @@
+//go:build unix
+
+package leader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// FileLock 基于 flock 的本机文件锁，适用于同一主机上的多个实例。
+// 进程退出时锁由内核自动释放。
+type FileLock struct {
+	path string
+	mu   sync.Mutex
+	f    *os.File
+}
+
+func NewFileLock(path string) *FileLock {
+	return &FileLock{path: path}
+}
+
+func (l *FileLock) TryAcquire(ctx context.Context) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f != nil {
+		return true, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return false, err
+	}
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return false, fmt.Errorf("failed to open lock file %s: %w", l.path, err)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to lock %s: %w", l.path, err)
+	}
+	f.Truncate(0)
+	fmt.Fprintf(f, "%d\n", os.Getpid())
+	l.f = f
+	return true, nil
+}
+
+func (l *FileLock) Release(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	err := syscall.Flock(int(l.f.Fd()), syscall.LOCK_UN)
+	l.f.Close()
+	l.f = nil
+	return err
+}
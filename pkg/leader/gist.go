@@ -0,0 +1,111 @@
+package leader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"controller/pkg/gist"
+)
+
+// GistLease 把租约以 JSON 文件的形式保存在 Gist 中 (通常就是结果 Gist)。
+// Gist 不支持条件更新，因此抢占时写入后会等待片刻再读回确认，属于尽力而为的互斥，
+// 在有 Kubernetes 的环境中应优先使用 KubernetesLease。
+type GistLease struct {
+	client   *gist.Client
+	gistID   string
+	file     string
+	identity string
+	duration time.Duration
+	settle   time.Duration // 抢占后读回确认前的等待时间
+
+	mu   sync.Mutex
+	held bool
+}
+
+type gistLeaseRecord struct {
+	Holder       string    `json:"holder"`
+	RenewedAt    time.Time `json:"renewed_at"`
+	LeaseSeconds int       `json:"lease_seconds"`
+}
+
+func NewGistLease(client *gist.Client, gistID, file, identity string, duration time.Duration) *GistLease {
+	return &GistLease{
+		client:   client,
+		gistID:   gistID,
+		file:     file,
+		identity: identity,
+		duration: duration,
+		settle:   2 * time.Second,
+	}
+}
+
+func (g *GistLease) TryAcquire(ctx context.Context) (bool, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	cur, err := g.read(ctx)
+	if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	expired := now.After(cur.RenewedAt.Add(time.Duration(cur.LeaseSeconds) * time.Second))
+	if cur.Holder != "" && cur.Holder != g.identity && !expired {
+		g.held = false
+		return false, nil
+	}
+
+	if err := g.write(ctx, gistLeaseRecord{Holder: g.identity, RenewedAt: now, LeaseSeconds: int(g.duration.Seconds())}); err != nil {
+		return false, err
+	}
+	if cur.Holder == g.identity {
+		g.held = true
+		return true, nil
+	}
+
+	// 抢占: 等待其他实例可能的并发写入后读回，最后写入者获胜
+	select {
+	case <-ctx.Done():
+		return false, ctx.Err()
+	case <-time.After(g.settle):
+	}
+	check, err := g.read(ctx)
+	if err != nil {
+		return false, err
+	}
+	g.held = check.Holder == g.identity
+	return g.held, nil
+}
+
+func (g *GistLease) Release(ctx context.Context) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.held {
+		return nil
+	}
+	g.held = false
+	cur, err := g.read(ctx)
+	if err != nil || cur.Holder != g.identity {
+		return err
+	}
+	return g.write(ctx, gistLeaseRecord{RenewedAt: time.Now()})
+}
+
+func (g *GistLease) read(ctx context.Context) (gistLeaseRecord, error) {
+	var rec gistLeaseRecord
+	content, found, err := g.client.ReadFile(ctx, g.gistID, g.file)
+	if err != nil || !found {
+		return rec, err
+	}
+	if err := json.Unmarshal([]byte(content), &rec); err != nil {
+		return rec, fmt.Errorf("invalid lease file %s in Gist %s: %w", g.file, g.gistID, err)
+	}
+	return rec, nil
+}
+
+func (g *GistLease) write(ctx context.Context, rec gistLeaseRecord) error {
+	data, _ := json.MarshalIndent(rec, "", "  ")
+	return g.client.UpdateFiles(ctx, g.gistID, map[string]string{g.file: string(data)})
+}
@@ -0,0 +1,295 @@
+package leader
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+	microTimeLayout   = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+// KubernetesLease 使用 coordination.k8s.io/v1 Lease 对象选主，通过 resourceVersion 保证并发更新的原子性
+type KubernetesLease struct {
+	baseURL   string
+	namespace string
+	name      string
+	identity  string
+	duration  time.Duration
+	tokenFile string
+	client    *http.Client
+
+	mu   sync.Mutex
+	held bool
+}
+
+type lease struct {
+	APIVersion string        `json:"apiVersion"`
+	Kind       string        `json:"kind"`
+	Metadata   leaseMetadata `json:"metadata"`
+	Spec       leaseSpec     `json:"spec"`
+
+	// raw 为读取到的完整对象，更新时在其上修改 spec，保留 labels、annotations 等其余字段
+	raw map[string]interface{}
+}
+
+type leaseMetadata struct {
+	Name            string `json:"name"`
+	Namespace       string `json:"namespace"`
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+type leaseSpec struct {
+	HolderIdentity       *string `json:"holderIdentity"`
+	LeaseDurationSeconds int     `json:"leaseDurationSeconds"`
+	AcquireTime          string  `json:"acquireTime,omitempty"`
+	RenewTime            string  `json:"renewTime,omitempty"`
+	LeaseTransitions     int     `json:"leaseTransitions"`
+}
+
+// NewKubernetesLease 创建 Lease 锁。apiURL、namespace、tokenFile、caFile 为空时使用 Pod 内的默认值
+func NewKubernetesLease(apiURL, namespace, name, tokenFile, caFile, identity string, duration time.Duration) (*KubernetesLease, error) {
+	if apiURL == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, fmt.Errorf("kubernetes api_url is not set and the controller is not running in a cluster")
+		}
+		apiURL = "https://" + host + ":" + port
+	}
+	if namespace == "" {
+		data, err := os.ReadFile(serviceAccountDir + "/namespace")
+		if err != nil {
+			return nil, fmt.Errorf("kubernetes namespace is not set: %w", err)
+		}
+		namespace = strings.TrimSpace(string(data))
+	}
+	if tokenFile == "" {
+		tokenFile = serviceAccountDir + "/token"
+	}
+	if caFile == "" {
+		if _, err := os.Stat(serviceAccountDir + "/ca.crt"); err == nil {
+			caFile = serviceAccountDir + "/ca.crt"
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read kubernetes CA %s: %w", caFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	return &KubernetesLease{
+		baseURL:   strings.TrimSuffix(apiURL, "/"),
+		namespace: namespace,
+		name:      name,
+		identity:  identity,
+		duration:  duration,
+		tokenFile: tokenFile,
+		client:    &http.Client{Transport: transport, Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (k *KubernetesLease) TryAcquire(ctx context.Context) (bool, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	now := time.Now()
+	cur, err := k.get(ctx)
+	if err != nil {
+		return false, err
+	}
+	if cur == nil {
+		l := k.newLease(now)
+		code, err := k.do(ctx, http.MethodPost, k.collectionURL(), l, nil)
+		if err != nil {
+			return false, err
+		}
+		k.held = code == http.StatusCreated || code == http.StatusOK
+		return k.held, nil
+	}
+
+	holder := ""
+	if cur.Spec.HolderIdentity != nil {
+		holder = *cur.Spec.HolderIdentity
+	}
+	if holder != k.identity && holder != "" && !leaseExpired(cur.Spec, now) {
+		k.held = false
+		return false, nil
+	}
+
+	next := *cur
+	id := k.identity
+	next.Spec.HolderIdentity = &id
+	next.Spec.LeaseDurationSeconds = int(k.duration.Seconds())
+	next.Spec.RenewTime = now.UTC().Format(microTimeLayout)
+	if holder != k.identity {
+		next.Spec.AcquireTime = next.Spec.RenewTime
+		next.Spec.LeaseTransitions++
+	}
+	obj, err := next.object()
+	if err != nil {
+		return false, err
+	}
+	code, err := k.do(ctx, http.MethodPut, k.objectURL(), obj, nil)
+	if err != nil {
+		return false, err
+	}
+	// 409 表示其他实例抢先更新了 Lease
+	k.held = code == http.StatusOK
+	return k.held, nil
+}
+
+func (k *KubernetesLease) Release(ctx context.Context) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if !k.held {
+		return nil
+	}
+	k.held = false
+	cur, err := k.get(ctx)
+	if err != nil || cur == nil || cur.Spec.HolderIdentity == nil || *cur.Spec.HolderIdentity != k.identity {
+		return err
+	}
+	empty := ""
+	cur.Spec.HolderIdentity = &empty
+	obj, err := cur.object()
+	if err != nil {
+		return err
+	}
+	_, err = k.do(ctx, http.MethodPut, k.objectURL(), obj, nil)
+	return err
+}
+
+func (k *KubernetesLease) newLease(now time.Time) lease {
+	id := k.identity
+	ts := now.UTC().Format(microTimeLayout)
+	return lease{
+		APIVersion: "coordination.k8s.io/v1",
+		Kind:       "Lease",
+		Metadata:   leaseMetadata{Name: k.name, Namespace: k.namespace},
+		Spec: leaseSpec{
+			HolderIdentity:       &id,
+			LeaseDurationSeconds: int(k.duration.Seconds()),
+			AcquireTime:          ts,
+			RenewTime:            ts,
+		},
+	}
+}
+
+func leaseExpired(spec leaseSpec, now time.Time) bool {
+	renewed, err := time.Parse(time.RFC3339Nano, spec.RenewTime)
+	if err != nil {
+		return true
+	}
+	return now.After(renewed.Add(time.Duration(spec.LeaseDurationSeconds) * time.Second))
+}
+
+func (k *KubernetesLease) get(ctx context.Context) (*lease, error) {
+	var raw map[string]interface{}
+	code, err := k.do(ctx, http.MethodGet, k.objectURL(), nil, &raw)
+	if err != nil {
+		return nil, err
+	}
+	if code == http.StatusNotFound {
+		return nil, nil
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	l := lease{raw: raw}
+	if err := json.Unmarshal(data, &l); err != nil {
+		return nil, fmt.Errorf("failed to decode lease %s: %w", k.name, err)
+	}
+	return &l, nil
+}
+
+// object 返回用于 PUT 的完整对象: 在读取到的对象上写入 l.Spec 中的字段，
+// metadata (含 resourceVersion、labels、annotations) 和 spec 中的其他字段保持不变
+func (l *lease) object() (map[string]interface{}, error) {
+	data, err := json.Marshal(l.Spec)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	obj := maps.Clone(l.raw)
+	if obj == nil {
+		obj = make(map[string]interface{})
+	}
+	spec, _ := obj["spec"].(map[string]interface{})
+	spec = maps.Clone(spec)
+	if spec == nil {
+		spec = make(map[string]interface{}, len(fields))
+	}
+	maps.Copy(spec, fields)
+	obj["spec"] = spec
+	return obj, nil
+}
+
+// do 发送请求并返回状态码; 200/201/404/409 视为正常结果，其余状态码返回错误
+func (k *KubernetesLease) do(ctx context.Context, method, url string, body, out interface{}) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if token, err := os.ReadFile(k.tokenFile); err == nil {
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		if out != nil {
+			return resp.StatusCode, json.NewDecoder(resp.Body).Decode(out)
+		}
+		return resp.StatusCode, nil
+	case http.StatusNotFound, http.StatusConflict:
+		return resp.StatusCode, nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return resp.StatusCode, fmt.Errorf("%s %s: %s: %s", method, url, resp.Status, strings.TrimSpace(string(msg)))
+}
+
+func (k *KubernetesLease) collectionURL() string {
+	return fmt.Sprintf("%s/apis/coordination.k8s.io/v1/namespaces/%s/leases", k.baseURL, k.namespace)
+}
+
+func (k *KubernetesLease) objectURL() string {
+	return k.collectionURL() + "/" + k.name
+}
@@ -0,0 +1,137 @@
+package leader
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"controller/pkg/logging"
+)
+
+// Lock 是选主使用的锁后端
+type Lock interface {
+	// TryAcquire 尝试获取或续约锁，返回当前实例是否持有锁
+	TryAcquire(ctx context.Context) (bool, error)
+	// Release 释放当前实例持有的锁
+	Release(ctx context.Context) error
+}
+
+// Elector 定期获取/续约锁，并记录当前实例是否为 leader。
+// 出错时视为失去 leader 身份，宁可暂停更新也不让两个实例同时写 DNS。
+type Elector struct {
+	lock     Lock
+	identity string
+	interval time.Duration
+	isLeader atomic.Bool
+	stop     context.CancelFunc
+	done     chan struct{}
+	logger   *slog.Logger
+
+	// [新增] 当前任期结束 (失去锁或释放) 时关闭，不是 leader 时为已关闭的 channel
+	termMu sync.Mutex
+	term   chan struct{}
+}
+
+func NewElector(lock Lock, identity string, renewInterval time.Duration) *Elector {
+	term := make(chan struct{})
+	close(term)
+	return &Elector{
+		lock:     lock,
+		identity: identity,
+		interval: renewInterval,
+		term:     term,
+		logger:   slog.With(logging.KeyPhase, "leader", "identity", identity),
+	}
+}
+
+// Start 同步尝试一次获取锁 (使首次运行即可知道自己的身份)，然后在后台定期续约
+func (e *Elector) Start(ctx context.Context) {
+	ctx, e.stop = context.WithCancel(ctx)
+	e.done = make(chan struct{})
+	e.tick(ctx)
+	go func() {
+		defer close(e.done)
+		ticker := time.NewTicker(e.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				e.release()
+				return
+			case <-ticker.C:
+				e.tick(ctx)
+			}
+		}
+	}()
+}
+
+// Stop 停止续约并释放锁
+func (e *Elector) Stop() {
+	if e.stop == nil {
+		return
+	}
+	e.stop()
+	<-e.done
+}
+
+func (e *Elector) IsLeader() bool {
+	return e.isLeader.Load()
+}
+
+func (e *Elector) Identity() string {
+	return e.identity
+}
+
+// Lost 返回在当前任期结束时关闭的 channel，当前不是 leader 时返回已关闭的 channel。
+// 运行中途失去 leader 身份时，调用方据此取消仍在进行的写入
+func (e *Elector) Lost() <-chan struct{} {
+	e.termMu.Lock()
+	defer e.termMu.Unlock()
+	return e.term
+}
+
+// setLeader 更新 leader 身份，返回之前的身份
+func (e *Elector) setLeader(held bool) bool {
+	e.termMu.Lock()
+	defer e.termMu.Unlock()
+	was := e.isLeader.Swap(held)
+	switch {
+	case held && !was:
+		e.term = make(chan struct{})
+	case !held && was:
+		close(e.term)
+	}
+	return was
+}
+
+func (e *Elector) tick(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, e.interval)
+	defer cancel()
+	held, err := e.lock.TryAcquire(ctx)
+	if err != nil {
+		e.logger.Warn("leader lock check failed", "error", err)
+		held = false
+	}
+	if was := e.setLeader(held); was != held {
+		if held {
+			e.logger.Info("became leader")
+		} else {
+			e.logger.Warn("lost leadership, DNS updates and result gist uploads paused")
+		}
+	}
+}
+
+func (e *Elector) release() {
+	if !e.setLeader(false) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := e.lock.Release(ctx); err != nil {
+		e.logger.Warn("failed to release leader lock", "error", err)
+		return
+	}
+	e.logger.Info("leader lock released")
+}
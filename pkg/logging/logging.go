@@ -0,0 +1,83 @@
+package logging
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"controller/pkg/config"
+	"controller/pkg/models"
+)
+
+// 所有日志统一使用的字段名
+const (
+	KeyRunID     = "run_id"
+	KeyPhase     = "phase"
+	KeyGistID    = "gist_id"
+	KeyOperator  = "operator"
+	KeyIPVersion = "ip_version"
+	KeyProvider  = "provider"
+	KeyGroup     = "group"
+)
+
+// Setup 按配置 (text/json 格式, 日志级别) 替换默认 logger。
+// 标准库 log 包的输出也会被转发到该 logger。
+func Setup(cfg config.Log) error {
+	return setup(os.Stderr, cfg)
+}
+
+func setup(w io.Writer, cfg config.Log) error {
+	level, err := ParseLevel(cfg.Level)
+	if err != nil {
+		return err
+	}
+	opts := &slog.HandlerOptions{Level: level}
+
+	var h slog.Handler
+	switch strings.ToLower(cfg.Format) {
+	case "", "text":
+		h = slog.NewTextHandler(w, opts)
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("unknown log format %q (want text or json)", cfg.Format)
+	}
+	slog.SetDefault(slog.New(h))
+	return nil
+}
+
+// ParseLevel 解析日志级别，空字符串视为 info
+func ParseLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(s) {
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "debug":
+		return slog.LevelDebug, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return slog.LevelInfo, fmt.Errorf("unknown log level %q", s)
+}
+
+// NewRunID 生成一次运行的随机 ID
+func NewRunID() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ForLine 为 "运营商-IP版本" 形式的线路 key (如 cu-v4) 附加 operator 和 ip_version 字段
+// [修改] 命名记录组的线路 (如 cdn/cu-v4) 另外附加 group 字段
+func ForLine(l *slog.Logger, key string) *slog.Logger {
+	group, op, ver := models.SplitLineKey(key)
+	if group != "" {
+		l = l.With(KeyGroup, group)
+	}
+	return l.With(KeyOperator, op, KeyIPVersion, ver)
+}
@@ -0,0 +1,58 @@
+package metrics
+
+import "time"
+
+// 运行阶段
+const (
+	PhaseFetch     = "fetch"
+	PhaseAggregate = "aggregate"
+	PhaseSelect    = "select"
+	PhaseProbe     = "probe"
+	PhaseUpdate    = "update"
+	PhaseUpload    = "upload"
+)
+
+// 阶段结果
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+	OutcomeSkipped = "skipped"
+)
+
+// Default 是控制器使用的全局注册表
+var Default = NewRegistry()
+
+var (
+	RunsTotal = Default.NewCounter("cfst_runs_total",
+		"Total number of controller runs by outcome.", "outcome")
+	RunDuration = Default.NewGauge("cfst_run_duration_seconds",
+		"Duration of the last controller run in seconds.")
+	LastRunTimestamp = Default.NewGauge("cfst_last_run_timestamp_seconds",
+		"Unix timestamp of the last finished run.")
+	PhaseDuration = Default.NewGauge("cfst_phase_duration_seconds",
+		"Duration of each phase in the last run in seconds.", "phase")
+	PhaseOutcomes = Default.NewCounter("cfst_phase_outcomes_total",
+		"Total number of phase executions by outcome.", "phase", "outcome")
+	ResultsIngested = Default.NewGauge("cfst_results_ingested",
+		"Number of device results ingested in the last run per device and line.", "device", "line")
+	QualifiedIPs = Default.NewGauge("cfst_qualified_ips",
+		"Number of qualified candidate IPs per line in the last run.", "line")
+	ActiveIPs = Default.NewGauge("cfst_active_ips",
+		"Number of active (to be published) IPs per line in the last run.", "line")
+	ActiveBestScore = Default.NewGauge("cfst_active_best_score",
+		"Best score among active IPs per line.", "line")
+	ActiveBestLatency = Default.NewGauge("cfst_active_best_latency_ms",
+		"Lowest latency among active IPs per line in milliseconds.", "line")
+	DNSUpdates = Default.NewCounter("cfst_dns_updates_total",
+		"Total number of DNS update calls per provider and result.", "provider", "result")
+	GistRateLimitRemaining = Default.NewGauge("cfst_gist_ratelimit_remaining",
+		"Remaining GitHub API requests reported by the last Gist API response.")
+	ResultUploadPending = Default.NewGauge("cfst_result_upload_pending",
+		"Whether a failed result gist upload is waiting to be retried (1) or not (0).")
+)
+
+// ObservePhase 记录某个阶段的耗时和结果
+func ObservePhase(phase string, start time.Time, outcome string) {
+	PhaseDuration.Set(time.Since(start).Seconds(), phase)
+	PhaseOutcomes.Inc(phase, outcome)
+}
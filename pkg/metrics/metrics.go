@@ -0,0 +1,138 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Registry 是一个极简的指标注册表，以 Prometheus 文本格式输出
+type Registry struct {
+	mu       sync.Mutex
+	families []*Vec
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Vec 是一组同名、按标签区分的 counter 或 gauge
+type Vec struct {
+	mu         sync.Mutex
+	name, help string
+	typ        string
+	labelNames []string
+	samples    map[string]*sample
+}
+
+type sample struct {
+	labelValues []string
+	value       float64
+}
+
+func (r *Registry) NewCounter(name, help string, labelNames ...string) *Vec {
+	return r.register(name, help, "counter", labelNames)
+}
+
+func (r *Registry) NewGauge(name, help string, labelNames ...string) *Vec {
+	return r.register(name, help, "gauge", labelNames)
+}
+
+func (r *Registry) register(name, help, typ string, labelNames []string) *Vec {
+	v := &Vec{name: name, help: help, typ: typ, labelNames: labelNames, samples: make(map[string]*sample)}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.families = append(r.families, v)
+	return v
+}
+
+func (v *Vec) get(labelValues []string) *sample {
+	if len(labelValues) != len(v.labelNames) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, ok := v.samples[key]
+	if !ok {
+		s = &sample{labelValues: append([]string(nil), labelValues...)}
+		v.samples[key] = s
+	}
+	return s
+}
+
+func (v *Vec) Add(delta float64, labelValues ...string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.get(labelValues).value += delta
+}
+
+func (v *Vec) Inc(labelValues ...string) {
+	v.Add(1, labelValues...)
+}
+
+func (v *Vec) Set(value float64, labelValues ...string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.get(labelValues).value = value
+}
+
+// Reset 清空所有样本，用于每次运行重新统计的 gauge (避免已消失的线路/设备残留旧值)
+func (v *Vec) Reset() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.samples = make(map[string]*sample)
+}
+
+// WriteText 以 Prometheus 文本格式 (0.0.4) 输出所有指标
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	families := append([]*Vec(nil), r.families...)
+	r.mu.Unlock()
+
+	var b strings.Builder
+	for _, v := range families {
+		v.mu.Lock()
+		fmt.Fprintf(&b, "# HELP %s %s\n", v.name, v.help)
+		fmt.Fprintf(&b, "# TYPE %s %s\n", v.name, v.typ)
+		keys := make([]string, 0, len(v.samples))
+		for k := range v.samples {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			s := v.samples[k]
+			b.WriteString(v.name)
+			if len(v.labelNames) > 0 {
+				b.WriteByte('{')
+				for i, ln := range v.labelNames {
+					if i > 0 {
+						b.WriteByte(',')
+					}
+					fmt.Fprintf(&b, "%s=\"%s\"", ln, escapeLabel(s.labelValues[i]))
+				}
+				b.WriteByte('}')
+			}
+			b.WriteByte(' ')
+			b.WriteString(strconv.FormatFloat(s.value, 'g', -1, 64))
+			b.WriteByte('\n')
+		}
+		v.mu.Unlock()
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Handler 返回输出指标的 HTTP handler
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteText(w)
+	})
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
@@ -5,6 +5,8 @@ package models
 import (
 	"encoding/json" // [修正] 导入 encoding/json 包
 	"fmt"
+	"regexp"
+	"strings"
 	"time"
 )
 
@@ -32,11 +34,31 @@ type SelectedItem struct {
 }
 
 // LineResult 在程序内部流转，包含一个线路（如 cu-v4）的所有合格及待更新IP
+// [修改] 增加 JSON 标签以便通过 HTTP API 输出
 type LineResult struct {
-	Operator   string
-	IPVersion  string // [新增] e.g., "v4", "v6"
-	Active     []SelectedItem
-	Candidates []SelectedItem
+	Group      string         `json:"group,omitempty"` // [新增] 所属记录组, 旧版单组配置时为空
+	Operator   string         `json:"operator"`
+	IPVersion  string         `json:"ip_version"` // [新增] e.g., "v4", "v6"
+	Active     []SelectedItem `json:"active"`
+	Candidates []SelectedItem `json:"candidates"`
+	Fallback   string         `json:"fallback,omitempty"` // [新增] 非空表示该线路没有合格 IP，记录实际采用的兜底策略
+}
+
+// [新增] LineKey 返回线路 key: 未命名记录组为 "cu-v4"，命名记录组为 "cdn/cu-v4"
+func LineKey(group, operator, ipVersion string) string {
+	if group == "" {
+		return operator + "-" + ipVersion
+	}
+	return group + "/" + operator + "-" + ipVersion
+}
+
+// [新增] SplitLineKey 是 LineKey 的逆操作
+func SplitLineKey(key string) (group, operator, ipVersion string) {
+	if i := strings.LastIndex(key, "/"); i >= 0 {
+		group, key = key[:i], key[i+1:]
+	}
+	operator, ipVersion, _ = strings.Cut(key, "-")
+	return group, operator, ipVersion
 }
 
 // --- [新增] 专用于 Gist JSON 文件输出的结构体 ---
@@ -44,12 +66,35 @@ type LineResult struct {
 // GistFileContent 是最终写入 Gist 中每个JSON文件的顶层结构
 type GistFileContent struct {
 	UpdatedAt string         `json:"updated_at"`
+	Fallback  string         `json:"fallback,omitempty"` // [新增]
+	Expired   bool           `json:"expired,omitempty"`  // [新增] 该线路本次没有结果, 文件仅保留为空结果
 	Results   []SelectedItem `json:"results"`
 }
 
+// [新增] LineFilePattern 匹配线路 key 转为文件名后的部分, 如 cu-v4 / cdn.cu-v4
+const LineFilePattern = `([A-Za-z0-9_-]+\.)?[a-z0-9]+-v[46]`
+
+var resultFileName = regexp.MustCompile(`^` + LineFilePattern + `\.json$`)
+
+// [新增] IsResultFileName 返回 name 是否为 BuildResultGistFiles 生成的线路文件名
+func IsResultFileName(name string) bool {
+	return resultFileName.MatchString(name)
+}
+
+// [新增] ExpiredResultFile 返回已过期线路文件的内容: 结果为空并标记 expired
+func ExpiredResultFile(updatedAt time.Time) string {
+	b, _ := json.MarshalIndent(GistFileContent{
+		UpdatedAt: updatedAt.Format(time.RFC3339),
+		Expired:   true,
+		Results:   []SelectedItem{},
+	}, "", "  ")
+	return string(b)
+}
+
 // BuildResultGistFiles 将优选结果构造成准备上传到 Gist 的多个文件
 // [重构] 此函数现在生成一个文件名到文件内容的映射
-func BuildResultGistFiles(sel map[string]LineResult) map[string]string {
+// [修改] updatedAt 由调用方传入, 以便在计算内容摘要时去掉时间戳
+func BuildResultGistFiles(sel map[string]LineResult, updatedAt time.Time) map[string]string {
 	filesToUpload := make(map[string]string)
 
 	// [修正] 移除了未使用的变量 key
@@ -58,11 +103,15 @@ func BuildResultGistFiles(sel map[string]LineResult) map[string]string {
 			continue // 如果没有任何合格的 IP，则不生成该文件
 		}
 
-		// 文件名格式: ct-v4.json, cu-v6.json 等
+		// 文件名格式: ct-v4.json, cu-v6.json 等; 命名记录组加上组前缀, 如 cdn.cu-v4.json
 		fileName := fmt.Sprintf("%s-%s.json", ln.Operator, ln.IPVersion)
+		if ln.Group != "" {
+			fileName = ln.Group + "." + fileName
+		}
 
 		content := GistFileContent{
-			UpdatedAt: time.Now().Format(time.RFC3339),
+			UpdatedAt: updatedAt.Format(time.RFC3339),
+			Fallback:  ln.Fallback,
 			Results:   ln.Candidates, // 上传所有合格的IP
 		}
 
@@ -0,0 +1,188 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"controller/pkg/config"
+	"controller/pkg/logging"
+	"controller/pkg/models"
+	"controller/pkg/probe"
+)
+
+// FailoverFunc 在某条线路需要紧急切换时被调用，负责更新该线路的 DNS 记录
+type FailoverFunc func(key string, lr models.LineResult) error
+
+// GiveUpFunc 在某条线路有 IP 失效但剩余健康 IP 不足 min_active、保留现有记录时被调用 (用于发送通知)
+type GiveUpFunc func(key string, err error)
+
+// Settings 是每轮监控使用的参数，由 Run 的 settings 回调每轮重新获取以跟随配置重载
+type Settings struct {
+	ProberFor func(key string) *probe.Prober       // 按线路 key 返回探测器 (不同记录组的 SNI 不同)
+	LineFor   func(key string) (config.Line, bool) // 按线路 key 返回线路配置 (min_active、max_score_gap)
+	Interval  time.Duration
+	Threshold int  // 连续失败多少次后切换
+	Standby   bool // 当前不是 leader: 不探测也不切换
+}
+
+// Monitor 在两次定时任务之间持续探测已发布到 DNS 的 IP。
+// 某个 IP 连续失败达到阈值后，使用上一次的候选列表为该线路重新选出 Active 并立即更新 DNS。
+type Monitor struct {
+	mu         sync.Mutex
+	published  map[string]models.LineResult // 已发布的线路结果 (含候选列表)
+	failures   map[string]map[string]int    // 线路 -> IP -> 连续失败次数
+	onFailover FailoverFunc
+	onGiveUp   GiveUpFunc
+}
+
+func New(onFailover FailoverFunc, onGiveUp GiveUpFunc) *Monitor {
+	return &Monitor{
+		published:  make(map[string]models.LineResult),
+		failures:   make(map[string]map[string]int),
+		onFailover: onFailover,
+		onGiveUp:   onGiveUp,
+	}
+}
+
+// SetPublished 记录最近一次成功发布的线路结果，并清空这些线路的失败计数
+func (m *Monitor) SetPublished(selected map[string]models.LineResult) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, lr := range selected {
+		if len(lr.Active) == 0 {
+			continue
+		}
+		m.published[key] = lr
+		delete(m.failures, key)
+	}
+}
+
+// Run 每隔 Interval 探测一次所有已发布的 IP，直到 ctx 被取消。
+// 探测器和参数每轮由 settings 获取，以便跟随配置重载。
+func (m *Monitor) Run(ctx context.Context, settings func() Settings) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(settings().Interval):
+		}
+		s := settings()
+		if s.Standby {
+			m.resetFailures()
+			continue
+		}
+		m.check(ctx, s)
+	}
+}
+
+// resetFailures 清空失败计数，避免重新成为 leader 后按过期的计数立即切换
+func (m *Monitor) resetFailures() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	clear(m.failures)
+}
+
+func (m *Monitor) check(ctx context.Context, s Settings) {
+	m.mu.Lock()
+	snapshot := make(map[string]models.LineResult, len(m.published))
+	for key, lr := range m.published {
+		snapshot[key] = lr
+	}
+	m.mu.Unlock()
+
+	for key, lr := range snapshot {
+		ips := make([]string, 0, len(lr.Active))
+		for _, it := range lr.Active {
+			ips = append(ips, it.IP)
+		}
+		prober := s.ProberFor(key)
+		results := prober.ProbeAll(ctx, ips)
+		if ctx.Err() != nil {
+			return
+		}
+
+		m.mu.Lock()
+		counts := m.failures[key]
+		if counts == nil {
+			counts = make(map[string]int)
+			m.failures[key] = counts
+		}
+		dead := make(map[string]bool)
+		for _, ip := range ips {
+			if results[ip].OK {
+				delete(counts, ip)
+				continue
+			}
+			counts[ip]++
+			logger(key).Warn("published IP failed probe", "ip", ip,
+				"consecutive_failures", counts[ip], "threshold", s.Threshold, "error", results[ip].Err)
+			if counts[ip] >= s.Threshold {
+				dead[ip] = true
+			}
+		}
+		m.mu.Unlock()
+
+		if len(dead) > 0 {
+			m.failover(ctx, prober, s, key, lr, dead)
+		}
+	}
+}
+
+// failover 剔除失效 IP，按上次的候选顺序递补 (遵守 max_score_gap) 并探测后更新该线路的 DNS。
+// 剩余健康 IP 不足 min_active 时与定时任务一致: 保留现有记录并通知，等待下一次定时任务
+func (m *Monitor) failover(ctx context.Context, prober *probe.Prober, s Settings, key string, lr models.LineResult, dead map[string]bool) {
+	ln, ok := s.LineFor(key)
+	if !ok {
+		logger(key).Warn("line no longer configured, skipping failover")
+		return
+	}
+	want := len(lr.Active)
+	var candidates []models.SelectedItem
+	for _, it := range lr.Candidates {
+		if !dead[it.IP] {
+			candidates = append(candidates, it)
+		}
+	}
+	next := models.LineResult{
+		Group:      lr.Group,
+		Operator:   lr.Operator,
+		IPVersion:  lr.IPVersion,
+		Active:     candidates[:min(want, len(candidates))],
+		Candidates: candidates,
+	}
+	verified, _ := prober.VerifyActive(ctx, map[string]models.LineResult{key: next}, func(string) float64 { return ln.MaxScoreGap })
+	if ctx.Err() != nil {
+		return
+	}
+	next = verified[key]
+	if n, minActive := len(next.Active), ln.MinActiveOrDefault(); n < minActive {
+		err := fmt.Errorf("only %d/%d healthy IPs left for failover, keeping the current record until the next scheduled run", n, minActive)
+		logger(key).Error("emergency failover skipped", "error", err)
+		if m.onGiveUp != nil {
+			m.onGiveUp(key, err)
+		}
+		return
+	}
+
+	logger(key).Warn("emergency failover triggered", "ips", activeIPs(next))
+	if err := m.onFailover(key, next); err != nil {
+		logger(key).Error("emergency failover failed", "error", err)
+		return
+	}
+	m.SetPublished(map[string]models.LineResult{key: next})
+}
+
+func logger(key string) *slog.Logger {
+	return logging.ForLine(slog.With(logging.KeyPhase, "monitor"), key)
+}
+
+func activeIPs(lr models.LineResult) []string {
+	ips := make([]string, 0, len(lr.Active))
+	for _, it := range lr.Active {
+		ips = append(ips, it.IP)
+	}
+	return ips
+}
@@ -0,0 +1,243 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"controller/pkg/config"
+)
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+func newSender(cc config.NotifyChannel) (Sender, error) {
+	switch strings.ToLower(cc.Type) {
+	case "webhook":
+		if cc.URL == "" {
+			return nil, fmt.Errorf("webhook requires 'url'")
+		}
+		return webhookSender{url: cc.URL}, nil
+	case "telegram":
+		if cc.BotToken == "" || cc.ChatID == "" {
+			return nil, fmt.Errorf("telegram requires 'bot_token' and 'chat_id'")
+		}
+		return telegramSender{token: cc.BotToken, chatID: cc.ChatID}, nil
+	case "dingtalk":
+		if cc.URL == "" {
+			return nil, fmt.Errorf("dingtalk requires 'url'")
+		}
+		return dingtalkSender{url: cc.URL, secret: cc.Secret}, nil
+	case "feishu":
+		if cc.URL == "" {
+			return nil, fmt.Errorf("feishu requires 'url'")
+		}
+		return feishuSender{url: cc.URL, secret: cc.Secret}, nil
+	case "wecom":
+		if cc.URL == "" {
+			return nil, fmt.Errorf("wecom requires 'url'")
+		}
+		return wecomSender{url: cc.URL}, nil
+	case "serverchan":
+		if cc.SendKey == "" {
+			return nil, fmt.Errorf("serverchan requires 'send_key'")
+		}
+		return serverChanSender{sendKey: cc.SendKey}, nil
+	case "bark":
+		if cc.DeviceKey == "" {
+			return nil, fmt.Errorf("bark requires 'device_key'")
+		}
+		server := cc.URL
+		if server == "" {
+			server = "https://api.day.app"
+		}
+		return barkSender{server: strings.TrimRight(server, "/"), deviceKey: cc.DeviceKey}, nil
+	case "smtp":
+		if cc.SMTPHost == "" || cc.From == "" || len(cc.To) == 0 {
+			return nil, fmt.Errorf("smtp requires 'smtp_host', 'from' and 'to'")
+		}
+		return smtpSender{cfg: cc}, nil
+	}
+	return nil, fmt.Errorf("unknown notify channel type %q", cc.Type)
+}
+
+// stripURL 去掉 *url.Error 中的完整地址: telegram、serverchan、钉钉等渠道的密钥位于 URL 的路径或参数中，
+// 不能随错误信息写入日志
+func stripURL(err error) error {
+	var uerr *url.Error
+	if errors.As(err, &uerr) {
+		return uerr.Err
+	}
+	return err
+}
+
+// postJSON 发送 JSON 请求，要求返回 2xx
+func postJSON(ctx context.Context, target string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", target, bytes.NewReader(data))
+	if err != nil {
+		return stripURL(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return do(req)
+}
+
+func do(req *http.Request) error {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s %s://%s: %w", req.Method, req.URL.Scheme, req.URL.Host, stripURL(err))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+func hmacBase64(key, msg string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(msg))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// webhookSender 以通用 JSON 格式推送到任意地址
+type webhookSender struct{ url string }
+
+func (s webhookSender) Send(ctx context.Context, ev Event) error {
+	return postJSON(ctx, s.url, map[string]interface{}{
+		"severity": ev.Severity.String(),
+		"key":      ev.Key,
+		"title":    ev.Title,
+		"text":     ev.Text,
+		"time":     ev.Time.Format(time.RFC3339),
+	})
+}
+
+type telegramSender struct{ token, chatID string }
+
+func (s telegramSender) Send(ctx context.Context, ev Event) error {
+	return postJSON(ctx, "https://api.telegram.org/bot"+s.token+"/sendMessage", map[string]interface{}{
+		"chat_id": s.chatID,
+		"text":    ev.Title + "\n\n" + ev.Text,
+	})
+}
+
+// dingtalkSender 钉钉自定义机器人，配置 secret 时使用加签方式
+type dingtalkSender struct{ url, secret string }
+
+func (s dingtalkSender) Send(ctx context.Context, ev Event) error {
+	target := s.url
+	if s.secret != "" {
+		ts := strconv.FormatInt(time.Now().UnixMilli(), 10)
+		sign := hmacBase64(s.secret, ts+"\n"+s.secret)
+		sep := "?"
+		if strings.Contains(target, "?") {
+			sep = "&"
+		}
+		target += sep + "timestamp=" + ts + "&sign=" + url.QueryEscape(sign)
+	}
+	return postJSON(ctx, target, map[string]interface{}{
+		"msgtype": "markdown",
+		"markdown": map[string]string{
+			"title": ev.Title,
+			"text":  "### " + ev.Title + "\n\n```\n" + ev.Text + "```",
+		},
+	})
+}
+
+// feishuSender 飞书自定义机器人，配置 secret 时使用签名校验
+type feishuSender struct{ url, secret string }
+
+func (s feishuSender) Send(ctx context.Context, ev Event) error {
+	body := map[string]interface{}{
+		"msg_type": "text",
+		"content":  map[string]string{"text": ev.Title + "\n\n" + ev.Text},
+	}
+	if s.secret != "" {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		body["timestamp"] = ts
+		body["sign"] = hmacBase64(ts+"\n"+s.secret, "")
+	}
+	return postJSON(ctx, s.url, body)
+}
+
+// wecomSender 企业微信群机器人
+type wecomSender struct{ url string }
+
+func (s wecomSender) Send(ctx context.Context, ev Event) error {
+	return postJSON(ctx, s.url, map[string]interface{}{
+		"msgtype":  "markdown",
+		"markdown": map[string]string{"content": "**" + ev.Title + "**\n" + ev.Text},
+	})
+}
+
+type serverChanSender struct{ sendKey string }
+
+func (s serverChanSender) Send(ctx context.Context, ev Event) error {
+	form := url.Values{"title": {ev.Title}, "desp": {"```\n" + ev.Text + "```"}}
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://sctapi.ftqq.com/"+s.sendKey+".send", strings.NewReader(form.Encode()))
+	if err != nil {
+		return stripURL(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return do(req)
+}
+
+type barkSender struct{ server, deviceKey string }
+
+func (s barkSender) Send(ctx context.Context, ev Event) error {
+	return postJSON(ctx, s.server+"/push", map[string]interface{}{
+		"device_key": s.deviceKey,
+		"title":      ev.Title,
+		"body":       ev.Text,
+		"group":      "cfst-controller",
+	})
+}
+
+type smtpSender struct{ cfg config.NotifyChannel }
+
+func (s smtpSender) Send(ctx context.Context, ev Event) error {
+	port := s.cfg.SMTPPort
+	if port == 0 {
+		port = 587
+	}
+	addr := net.JoinHostPort(s.cfg.SMTPHost, strconv.Itoa(port))
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", s.cfg.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(s.cfg.To, ", "))
+	fmt.Fprintf(&msg, "Subject: =?UTF-8?B?%s?=\r\n", base64.StdEncoding.EncodeToString([]byte(ev.Title)))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(ev.Text, "\n", "\r\n"))
+
+	var auth smtp.Auth
+	if s.cfg.Username != "" {
+		auth = smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, s.cfg.SMTPHost)
+	}
+	// net/smtp 不支持 context，这里在独立协程中发送并遵守超时
+	done := make(chan error, 1)
+	go func() { done <- smtp.SendMail(addr, auth, s.cfg.From, s.cfg.To, msg.Bytes()) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,84 @@
+package notify
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"controller/pkg/models"
+)
+
+// DNSChangeEvent 构造某条线路发布 IP 变化的通知，old 为 nil 表示之前的记录未知
+func DNSChangeEvent(line, recordName string, old, new []models.SelectedItem) Event {
+	var b strings.Builder
+	fmt.Fprintf(&b, "线路: %s\n记录: %s\n", line, recordName)
+	b.WriteString("\n旧 IP:\n")
+	if old == nil {
+		b.WriteString("  (未知)\n")
+	} else {
+		writeItems(&b, old)
+	}
+	b.WriteString("\n新 IP:\n")
+	writeItems(&b, new)
+
+	return Event{
+		Severity: SeverityInfo,
+		Key:      "dns:" + line + ":" + strings.Join(itemIPs(new), ","),
+		Title:    fmt.Sprintf("[DNS] %s 发布 IP 已变更", line),
+		Text:     b.String(),
+	}
+}
+
+// FailureEvent 构造某个阶段失败的通知，scope 用于区分同一阶段的不同对象 (如 Gist ID)
+func FailureEvent(sev Severity, phase, scope string, err error) Event {
+	title := fmt.Sprintf("[%s] %s 阶段失败", strings.ToUpper(sev.String()), phase)
+	text := fmt.Sprintf("阶段: %s\n", phase)
+	if scope != "" {
+		title += " (" + scope + ")"
+		text += fmt.Sprintf("对象: %s\n", scope)
+	}
+	text += fmt.Sprintf("错误: %v\n", err)
+	return Event{
+		Severity: sev,
+		Key:      "failure:" + phase + ":" + scope,
+		Title:    title,
+		Text:     text,
+	}
+}
+
+// SameIPs 判断两组 IP 是否相同 (忽略顺序)
+func SameIPs(a, b []models.SelectedItem) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	x, y := itemIPs(a), itemIPs(b)
+	for i := range x {
+		if x[i] != y[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func writeItems(b *strings.Builder, items []models.SelectedItem) {
+	if len(items) == 0 {
+		b.WriteString("  (无)\n")
+		return
+	}
+	for _, it := range items {
+		colo := it.Region
+		if colo == "" {
+			colo = "-"
+		}
+		fmt.Fprintf(b, "  %s  延迟 %dms  速度 %.2fMbps  colo %s\n", it.IP, it.LatencyMs, it.DLMbps, colo)
+	}
+}
+
+func itemIPs(items []models.SelectedItem) []string {
+	ips := make([]string, 0, len(items))
+	for _, it := range items {
+		ips = append(ips, it.IP)
+	}
+	sort.Strings(ips)
+	return ips
+}
@@ -0,0 +1,151 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"controller/pkg/config"
+)
+
+// Severity 事件级别
+type Severity int
+
+const (
+	SeverityInfo Severity = iota
+	SeverityWarning
+	SeverityError
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	default:
+		return "info"
+	}
+}
+
+// ParseSeverity 解析配置中的级别，空字符串视为 info
+func ParseSeverity(s string) (Severity, error) {
+	switch strings.ToLower(s) {
+	case "", "info":
+		return SeverityInfo, nil
+	case "warn", "warning":
+		return SeverityWarning, nil
+	case "error":
+		return SeverityError, nil
+	}
+	return SeverityInfo, fmt.Errorf("unknown severity %q", s)
+}
+
+// Event 是一条待发送的通知
+type Event struct {
+	Severity Severity
+	// Key 用于限流: 相同 Key 的事件在 min_interval 内只发送一次
+	Key   string
+	Title string
+	Text  string
+	Time  time.Time
+}
+
+// Sender 是一个具体的通知渠道
+type Sender interface {
+	Send(ctx context.Context, ev Event) error
+}
+
+type channel struct {
+	name        string
+	minSeverity Severity
+	sender      Sender
+}
+
+// Notifier 按级别过滤并限流后，将事件分发到所有已配置的渠道
+type Notifier struct {
+	mu          sync.Mutex
+	channels    []channel
+	minInterval time.Duration
+	lastSent    map[string]time.Time
+	dryRun      bool // [新增] 只记录日志，不发送到任何渠道
+}
+
+func New() *Notifier {
+	return &Notifier{lastSent: make(map[string]time.Time)}
+}
+
+// Configure 根据配置重建通知渠道，限流状态在多次重载之间保留
+func (n *Notifier) Configure(cfg config.Notify) error {
+	var channels []channel
+	for i, cc := range cfg.Channels {
+		sev, err := ParseSeverity(cc.MinSeverity)
+		if err != nil {
+			return fmt.Errorf("notify.channels[%d]: %w", i, err)
+		}
+		sender, err := newSender(cc)
+		if err != nil {
+			return fmt.Errorf("notify.channels[%d]: %w", i, err)
+		}
+		channels = append(channels, channel{name: cc.Type, minSeverity: sev, sender: sender})
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.channels = channels
+	n.minInterval = time.Duration(cfg.MinIntervalMinutes) * time.Minute
+	return nil
+}
+
+// SetDryRun 为 true 时 Notify 只记录将要发送的事件 (用于 run --dry-run)
+func (n *Notifier) SetDryRun(dryRun bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.dryRun = dryRun
+}
+
+// Notify 发送事件。发送失败只记录日志，不影响主流程。
+func (n *Notifier) Notify(ev Event) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+
+	n.mu.Lock()
+	if len(n.channels) == 0 {
+		n.mu.Unlock()
+		return
+	}
+	if n.dryRun {
+		n.mu.Unlock()
+		slog.Info("dry-run: notification not sent", "severity", ev.Severity.String(), "title", ev.Title)
+		return
+	}
+	if last, ok := n.lastSent[ev.Key]; ok && ev.Key != "" && ev.Time.Sub(last) < n.minInterval {
+		n.mu.Unlock()
+		slog.Debug("notification suppressed by rate limit", "key", ev.Key, "last_sent", last)
+		return
+	}
+	n.lastSent[ev.Key] = ev.Time
+	// 顺便清理过期的限流记录
+	for k, t := range n.lastSent {
+		if ev.Time.Sub(t) > n.minInterval && k != ev.Key {
+			delete(n.lastSent, k)
+		}
+	}
+	channels := append([]channel(nil), n.channels...)
+	n.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	for _, ch := range channels {
+		if ev.Severity < ch.minSeverity {
+			continue
+		}
+		if err := ch.sender.Send(ctx, ev); err != nil {
+			slog.Warn("failed to send notification", "channel", ch.name, "key", ev.Key, "error", err)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package probe
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"controller/pkg/config"
+	"controller/pkg/models"
+	"controller/pkg/selector"
+)
+
+// Result 是对单个 IP 的一次探测结果
+type Result struct {
+	IP      string
+	OK      bool
+	Latency time.Duration // TCP 连接 + TLS 握手 (+ HTTP 请求) 的总耗时
+	Err     error
+}
+
+// Prober 在发布前由控制器自行对候选 IP 进行探测:
+// TCP 连接到 Port，以 SNI 完成 TLS 握手，可选地再通过同一连接 GET TraceURL。
+type Prober struct {
+	Port               int
+	SNI                string
+	TraceURL           string
+	Timeout            time.Duration
+	Concurrency        int
+	InsecureSkipVerify bool
+	// RootCAs 为 nil 时使用系统根证书，可替换为自签证书以便对本地监听端口进行测试
+	RootCAs *x509.CertPool
+}
+
+// New 根据配置创建 Prober，defaultSNI 在未配置 sni 时使用 (通常为待发布的记录名)
+func New(cfg config.Probe, defaultSNI string) *Prober {
+	p := &Prober{
+		Port:               cfg.Port,
+		SNI:                cfg.SNI,
+		TraceURL:           cfg.TraceURL,
+		Timeout:            time.Duration(cfg.TimeoutSeconds) * time.Second,
+		Concurrency:        cfg.Concurrency,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if p.Port <= 0 {
+		p.Port = 443
+	}
+	if p.SNI == "" {
+		p.SNI = defaultSNI
+	}
+	if p.Timeout <= 0 {
+		p.Timeout = 5 * time.Second
+	}
+	if p.Concurrency <= 0 {
+		p.Concurrency = 8
+	}
+	return p
+}
+
+// Probe 探测单个 IP
+func (p *Prober) Probe(ctx context.Context, ip string) Result {
+	ctx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	addr := net.JoinHostPort(ip, strconv.Itoa(p.Port))
+	dialer := &tls.Dialer{Config: &tls.Config{
+		ServerName:         p.SNI,
+		InsecureSkipVerify: p.InsecureSkipVerify,
+		RootCAs:            p.RootCAs,
+	}}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return Result{IP: ip, Err: fmt.Errorf("tls dial %s (sni: %s): %w", addr, p.SNI, err)}
+	}
+	defer conn.Close()
+
+	if p.TraceURL != "" {
+		if err := p.getTrace(ctx, conn); err != nil {
+			return Result{IP: ip, Err: err}
+		}
+	}
+	return Result{IP: ip, OK: true, Latency: time.Since(start)}
+}
+
+// getTrace 复用已建立的 TLS 连接请求 TraceURL，要求返回 2xx
+func (p *Prober) getTrace(ctx context.Context, conn net.Conn) error {
+	u, err := url.Parse(p.TraceURL)
+	if err != nil {
+		return fmt.Errorf("invalid trace url %q: %w", p.TraceURL, err)
+	}
+	used := false
+	transport := &http.Transport{
+		DialTLSContext: func(context.Context, string, string) (net.Conn, error) {
+			if used {
+				return nil, fmt.Errorf("probe connection already used")
+			}
+			used = true
+			return conn, nil
+		},
+		DisableKeepAlives: true,
+	}
+	defer transport.CloseIdleConnections()
+
+	// 连接已经指向目标 IP，这里只需要保证请求走 https 以触发 DialTLSContext
+	u.Scheme = "https"
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := (&http.Client{Transport: transport}).Do(req)
+	if err != nil {
+		return fmt.Errorf("GET %s: %w", p.TraceURL, err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("GET %s: unexpected status %s", p.TraceURL, resp.Status)
+	}
+	return nil
+}
+
+// ProbeAll 以 Concurrency 的并发度探测所有 IP，返回 IP 到结果的映射
+func (p *Prober) ProbeAll(ctx context.Context, ips []string) map[string]Result {
+	results := make(map[string]Result, len(ips))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, p.Concurrency)
+	for _, ip := range ips {
+		wg.Add(1)
+		go func(ip string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			r := p.Probe(ctx, ip)
+			mu.Lock()
+			results[ip] = r
+			mu.Unlock()
+		}(ip)
+	}
+	wg.Wait()
+	return results
+}
+
+// VerifyActive 探测每条线路的 Active IP，剔除失败的 IP 并按候选顺序递补，直到补满原有数量或候选耗尽。
+// 只递补与当前第一名分差在 max_score_gap 内的候选，maxScoreGap 按线路 key 返回该值 (为 nil 时不限制)。
+// 失败的 IP 同时从 Candidates 中移除；若某线路最终没有可用 IP，则 Active 置空并记录兜底原因，DNS 保持现有记录。
+// 探测后不足 min_active 的线路由调用方通过 selector.RefillGroups 重新兜底。结果写入新的 map，不修改 selected
+func (p *Prober) VerifyActive(ctx context.Context, selected map[string]models.LineResult, maxScoreGap func(key string) float64) (map[string]models.LineResult, map[string][]Result) {
+	verified := make(map[string]models.LineResult, len(selected))
+	failures := make(map[string][]Result)
+	for key, lr := range selected {
+		verified[key] = lr
+		want := len(lr.Active)
+		if want == 0 {
+			continue
+		}
+		var maxGap float64
+		if maxScoreGap != nil {
+			maxGap = maxScoreGap(key)
+		}
+
+		var active []models.SelectedItem
+		failed := make(map[string]bool)
+		next, exhausted := 0, false
+		for len(active) < want && next < len(lr.Candidates) && !exhausted && ctx.Err() == nil {
+			batch := lr.Candidates[next:min(next+want-len(active), len(lr.Candidates))]
+			next += len(batch)
+
+			ips := make([]string, 0, len(batch))
+			for _, it := range batch {
+				ips = append(ips, it.IP)
+			}
+			results := p.ProbeAll(ctx, ips)
+			for _, it := range batch {
+				r := results[it.IP]
+				switch {
+				case !r.OK:
+					failed[it.IP] = true
+					failures[key] = append(failures[key], r)
+				case exhausted:
+				case len(active) > 0 && !selector.WithinScoreGap(active[0].Score, it.Score, maxGap):
+					// 候选按分数降序排列，之后的候选同样超出分差
+					exhausted = true
+				default:
+					active = append(active, it)
+				}
+			}
+		}
+		if len(failed) == 0 && len(active) == want {
+			continue
+		}
+
+		var candidates []models.SelectedItem
+		for _, it := range lr.Candidates {
+			if !failed[it.IP] {
+				candidates = append(candidates, it)
+			}
+		}
+		lr.Active, lr.Candidates = active, candidates
+		if len(active) == 0 {
+			lr.Fallback = "keep (all active IPs failed controller probe)"
+		}
+		verified[key] = lr
+	}
+	return verified, failures
+}
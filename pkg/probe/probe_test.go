@@ -0,0 +1,174 @@
+package probe
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"controller/pkg/models"
+)
+
+// newTestServer 启动本地 TLS 监听，/trace 返回 200，/broken 返回 500
+func newTestServer(t *testing.T) (*httptest.Server, *Prober) {
+	t.Helper()
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/broken" {
+			http.Error(w, "broken", http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("ip=127.0.0.1\n"))
+	}))
+	t.Cleanup(srv.Close)
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+
+	// httptest 的证书对 example.com 有效
+	return srv, &Prober{
+		Port:        port,
+		SNI:         "example.com",
+		TraceURL:    "https://example.com/trace",
+		Timeout:     2 * time.Second,
+		Concurrency: 2,
+		RootCAs:     roots,
+	}
+}
+
+func TestProbeSuccess(t *testing.T) {
+	_, p := newTestServer(t)
+
+	r := p.Probe(context.Background(), "127.0.0.1")
+	if !r.OK || r.Err != nil {
+		t.Fatalf("probe failed: %v", r.Err)
+	}
+	if r.Latency <= 0 {
+		t.Errorf("latency = %v, want > 0", r.Latency)
+	}
+}
+
+func TestProbeHandshakeFailure(t *testing.T) {
+	_, p := newTestServer(t)
+	p.SNI = "mismatch.invalid"
+
+	if r := p.Probe(context.Background(), "127.0.0.1"); r.OK || r.Err == nil {
+		t.Fatal("probe with mismatched SNI succeeded")
+	}
+
+	_, p = newTestServer(t)
+	p.RootCAs = nil
+	if r := p.Probe(context.Background(), "127.0.0.1"); r.OK || r.Err == nil {
+		t.Fatal("probe with untrusted certificate succeeded")
+	}
+}
+
+func TestProbeTraceNon2xx(t *testing.T) {
+	_, p := newTestServer(t)
+	p.TraceURL = "https://example.com/broken"
+
+	r := p.Probe(context.Background(), "127.0.0.1")
+	if r.OK || r.Err == nil {
+		t.Fatal("probe with 500 trace response succeeded")
+	}
+}
+
+func TestVerifyActivePromotesCandidate(t *testing.T) {
+	_, p := newTestServer(t)
+
+	// 127.0.0.2 上没有监听，探测失败后应由下一个候选递补
+	bad := models.SelectedItem{IP: "127.0.0.2", Score: 90}
+	good := models.SelectedItem{IP: "127.0.0.1", Score: 80}
+	selected := map[string]models.LineResult{
+		"ct-v4": {
+			Operator:   "ct",
+			IPVersion:  "v4",
+			Active:     []models.SelectedItem{bad},
+			Candidates: []models.SelectedItem{bad, good},
+		},
+	}
+
+	verified, failures := p.VerifyActive(context.Background(), selected, nil)
+	lr := verified["ct-v4"]
+	if len(lr.Active) != 1 || lr.Active[0].IP != good.IP {
+		t.Fatalf("active = %+v, want [%s]", lr.Active, good.IP)
+	}
+	if len(lr.Candidates) != 1 || lr.Candidates[0].IP != good.IP {
+		t.Errorf("candidates = %+v, want [%s]", lr.Candidates, good.IP)
+	}
+	if lr.Fallback != "" {
+		t.Errorf("fallback = %q, want empty", lr.Fallback)
+	}
+	if f := failures["ct-v4"]; len(f) != 1 || f[0].IP != bad.IP {
+		t.Errorf("failures = %+v, want [%s]", f, bad.IP)
+	}
+	if in := selected["ct-v4"]; len(in.Active) != 1 || in.Active[0].IP != bad.IP || len(in.Candidates) != 2 {
+		t.Errorf("input map modified: %+v", in)
+	}
+}
+
+func TestVerifyActiveRespectsScoreGap(t *testing.T) {
+	_, p := newTestServer(t)
+
+	// ::ffff:127.0.0.1 与 127.0.0.1 指向同一监听端口，127.0.0.2 上没有监听
+	top := models.SelectedItem{IP: "127.0.0.1", Score: 100}
+	bad := models.SelectedItem{IP: "127.0.0.2", Score: 95}
+	low := models.SelectedItem{IP: "::ffff:127.0.0.1", Score: 50}
+
+	tests := []struct {
+		name       string
+		line       models.LineResult
+		maxGap     float64
+		wantActive []string
+	}{
+		{
+			name:       "candidate beyond gap not promoted",
+			line:       models.LineResult{Active: []models.SelectedItem{top, bad}, Candidates: []models.SelectedItem{top, bad, low}},
+			maxGap:     0.3,
+			wantActive: []string{top.IP},
+		},
+		{
+			name:       "candidate within gap promoted",
+			line:       models.LineResult{Active: []models.SelectedItem{top, bad}, Candidates: []models.SelectedItem{top, bad, low}},
+			maxGap:     0.6,
+			wantActive: []string{top.IP, low.IP},
+		},
+		{
+			name:       "no gap limit",
+			line:       models.LineResult{Active: []models.SelectedItem{top, bad}, Candidates: []models.SelectedItem{top, bad, low}},
+			wantActive: []string{top.IP, low.IP},
+		},
+		{
+			// 第一名失败后由通过探测的最高分候选成为新的第一名
+			name:       "failed leader replaced",
+			line:       models.LineResult{Active: []models.SelectedItem{bad}, Candidates: []models.SelectedItem{bad, low}},
+			maxGap:     0.1,
+			wantActive: []string{low.IP},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selected := map[string]models.LineResult{"cu-v4": tt.line}
+			verified, _ := p.VerifyActive(context.Background(), selected, func(string) float64 { return tt.maxGap })
+			var got []string
+			for _, it := range verified["cu-v4"].Active {
+				got = append(got, it.IP)
+			}
+			if !slices.Equal(got, tt.wantActive) {
+				t.Errorf("active = %v, want %v", got, tt.wantActive)
+			}
+		})
+	}
+}
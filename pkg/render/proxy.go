@@ -0,0 +1,204 @@
+package render
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"controller/pkg/config"
+	"gopkg.in/yaml.v2"
+)
+
+// clashProxy 是 Clash / mihomo 的一个节点
+type clashProxy struct {
+	Name       string            `yaml:"name"`
+	Type       string            `yaml:"type"`
+	Server     string            `yaml:"server"`
+	Port       int               `yaml:"port"`
+	UUID       string            `yaml:"uuid,omitempty"`
+	AlterID    *int              `yaml:"alterId,omitempty"`
+	Cipher     string            `yaml:"cipher,omitempty"`
+	Password   string            `yaml:"password,omitempty"`
+	Network    string            `yaml:"network,omitempty"`
+	TLS        bool              `yaml:"tls,omitempty"`
+	ServerName string            `yaml:"servername,omitempty"`
+	SNI        string            `yaml:"sni,omitempty"`
+	UDP        bool              `yaml:"udp"`
+	WSOpts     *clashWSOpts      `yaml:"ws-opts,omitempty"`
+	GRPCOpts   map[string]string `yaml:"grpc-opts,omitempty"`
+}
+
+type clashWSOpts struct {
+	Path    string            `yaml:"path,omitempty"`
+	Headers map[string]string `yaml:"headers,omitempty"`
+}
+
+// renderClash 生成 Clash / mihomo proxy-provider 文件 (proxies 列表)
+func renderClash(pools []pool, proxy config.Proxy) (string, error) {
+	var proxies []clashProxy
+	for _, p := range pools {
+		for i, ip := range p.ips {
+			cp := clashProxy{
+				Name:    nodeName(p.key, i),
+				Type:    proxy.Type,
+				Server:  ip,
+				Port:    proxy.PortOrDefault(),
+				Network: proxy.NetworkOrDefault(),
+				TLS:     proxy.TLS,
+				UDP:     true,
+			}
+			switch proxy.Type {
+			case config.ProxyTrojan:
+				cp.Password, cp.SNI, cp.TLS = proxy.Password, proxy.ServerName(), false
+			case config.ProxyVMess:
+				zero := 0
+				cp.UUID, cp.AlterID, cp.Cipher = proxy.UUID, &zero, "auto"
+			default:
+				cp.UUID = proxy.UUID
+			}
+			if cp.TLS {
+				cp.ServerName = proxy.ServerName()
+			}
+			switch cp.Network {
+			case "ws":
+				cp.WSOpts = &clashWSOpts{Path: proxy.Path}
+				if proxy.Host != "" {
+					cp.WSOpts.Headers = map[string]string{"Host": proxy.Host}
+				}
+			case "grpc":
+				cp.GRPCOpts = map[string]string{"grpc-service-name": proxy.Path}
+			case "tcp":
+				cp.Network = ""
+			}
+			proxies = append(proxies, cp)
+		}
+	}
+	b, err := yaml.Marshal(struct {
+		Proxies []clashProxy `yaml:"proxies"`
+	}{proxies})
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// renderSingBox 生成 sing-box 的 outbounds 列表
+func renderSingBox(pools []pool, proxy config.Proxy) (string, error) {
+	var outbounds []map[string]interface{}
+	for _, p := range pools {
+		for i, ip := range p.ips {
+			ob := map[string]interface{}{
+				"type":        proxy.Type,
+				"tag":         nodeName(p.key, i),
+				"server":      ip,
+				"server_port": proxy.PortOrDefault(),
+			}
+			switch proxy.Type {
+			case config.ProxyTrojan:
+				ob["password"] = proxy.Password
+			case config.ProxyVMess:
+				ob["uuid"], ob["security"], ob["alter_id"] = proxy.UUID, "auto", 0
+			default:
+				ob["uuid"] = proxy.UUID
+			}
+			if proxy.TLS {
+				ob["tls"] = map[string]interface{}{"enabled": true, "server_name": proxy.ServerName()}
+			}
+			switch proxy.NetworkOrDefault() {
+			case "ws":
+				transport := map[string]interface{}{"type": "ws", "path": proxy.Path}
+				if proxy.Host != "" {
+					transport["headers"] = map[string]string{"Host": proxy.Host}
+				}
+				ob["transport"] = transport
+			case "grpc":
+				ob["transport"] = map[string]interface{}{"type": "grpc", "service_name": proxy.Path}
+			}
+			outbounds = append(outbounds, ob)
+		}
+	}
+	b, err := json.MarshalIndent(map[string]interface{}{"outbounds": outbounds}, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// renderV2rayN 生成 v2rayN 订阅: 每行一个分享链接，整体 base64 编码
+func renderV2rayN(pools []pool, proxy config.Proxy) (string, error) {
+	var links []string
+	for _, p := range pools {
+		for i, ip := range p.ips {
+			link, err := shareLink(nodeName(p.key, i), ip, proxy)
+			if err != nil {
+				return "", err
+			}
+			links = append(links, link)
+		}
+	}
+	return base64.StdEncoding.EncodeToString([]byte(strings.Join(links, "\n"))), nil
+}
+
+// shareLink 生成单个节点的分享链接 (vless:// / trojan:// / vmess://)
+func shareLink(name, ip string, proxy config.Proxy) (string, error) {
+	port := proxy.PortOrDefault()
+	network := proxy.NetworkOrDefault()
+	security := "none"
+	if proxy.TLS {
+		security = "tls"
+	}
+
+	if proxy.Type == config.ProxyVMess {
+		tls := ""
+		if proxy.TLS {
+			tls = "tls"
+		}
+		b, err := json.Marshal(map[string]string{
+			"v": "2", "ps": name, "add": ip, "port": strconv.Itoa(port), "id": proxy.UUID, "aid": "0",
+			"scy": "auto", "net": network, "type": "none", "host": proxy.Host, "path": proxy.Path,
+			"tls": tls, "sni": proxy.ServerName(),
+		})
+		if err != nil {
+			return "", err
+		}
+		return "vmess://" + base64.StdEncoding.EncodeToString(b), nil
+	}
+
+	q := url.Values{}
+	q.Set("security", security)
+	q.Set("type", network)
+	if sni := proxy.ServerName(); proxy.TLS && sni != "" {
+		q.Set("sni", sni)
+	}
+	switch network {
+	case "ws":
+		if proxy.Host != "" {
+			q.Set("host", proxy.Host)
+		}
+		q.Set("path", proxy.Path)
+	case "grpc":
+		q.Set("serviceName", proxy.Path)
+	}
+
+	u := url.URL{
+		Scheme:   proxy.Type,
+		Host:     net.JoinHostPort(ip, strconv.Itoa(port)),
+		RawQuery: q.Encode(),
+		Fragment: name,
+	}
+	switch proxy.Type {
+	case config.ProxyTrojan:
+		u.User = url.User(proxy.Password)
+	case config.ProxyVLESS:
+		q.Set("encryption", "none")
+		u.RawQuery = q.Encode()
+		u.User = url.User(proxy.UUID)
+	default:
+		return "", fmt.Errorf("unsupported proxy type %q", proxy.Type)
+	}
+	return u.String(), nil
+}
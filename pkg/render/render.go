@@ -0,0 +1,102 @@
+// Package render 将优选出的 IP 池渲染为下游工具可直接使用的格式 (ip.txt、CloudflareST、Clash、sing-box、v2rayN)
+package render
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"controller/pkg/config"
+	"controller/pkg/models"
+)
+
+// pool 是一条线路参与渲染的 IP
+type pool struct {
+	key string
+	ips []string
+}
+
+// renderer 将若干线路的 IP 池渲染为文件内容
+type renderer func(pools []pool, proxy config.Proxy) (string, error)
+
+var renderers = map[string]renderer{
+	config.OutputIPTxt:   renderIPTxt,
+	config.OutputCFST:    renderCFST,
+	config.OutputClash:   renderClash,
+	config.OutputSingBox: renderSingBox,
+	config.OutputV2rayN:  renderV2rayN,
+}
+
+// Files 按 outputs 配置渲染 selected 中的线路，返回文件名到内容的映射。
+// 文件名包含 {line} 时每条线路生成一个文件 (线路 key 中的 "/" 替换为 ".")，否则所有线路合并为一个文件。
+// 没有任何 IP 的文件不会生成。
+func Files(outputs []config.Output, selected map[string]models.LineResult) (map[string]string, error) {
+	keys := make([]string, 0, len(selected))
+	for key := range selected {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	files := make(map[string]string)
+	for _, o := range outputs {
+		render, ok := renderers[o.Format]
+		if !ok {
+			return nil, fmt.Errorf("unknown output format %q", o.Format)
+		}
+		pools := collect(o, keys, selected)
+		name := o.DefaultFile()
+
+		var batches [][]pool
+		if strings.Contains(name, "{line}") {
+			for _, p := range pools {
+				batches = append(batches, []pool{p})
+			}
+		} else if len(pools) > 0 {
+			batches = [][]pool{pools}
+		}
+		for _, batch := range batches {
+			content, err := render(batch, o.Proxy)
+			if err != nil {
+				return nil, fmt.Errorf("failed to render %s output: %w", o.Format, err)
+			}
+			fileName := name
+			if len(batch) == 1 {
+				fileName = strings.ReplaceAll(name, "{line}", strings.ReplaceAll(batch[0].key, "/", "."))
+			}
+			files[fileName] = content
+		}
+	}
+	return files, nil
+}
+
+// collect 按线路过滤和 pool 设置取出各线路的 IP，跳过没有 IP 的线路
+func collect(o config.Output, keys []string, selected map[string]models.LineResult) []pool {
+	want := make(map[string]bool, len(o.Lines))
+	for _, key := range o.Lines {
+		want[key] = true
+	}
+	var pools []pool
+	for _, key := range keys {
+		if len(want) > 0 && !want[key] {
+			continue
+		}
+		items := selected[key].Candidates
+		if o.Pool == config.PoolActive {
+			items = selected[key].Active
+		}
+		if len(items) == 0 {
+			continue
+		}
+		p := pool{key: key}
+		for _, it := range items {
+			p.ips = append(p.ips, it.IP)
+		}
+		pools = append(pools, p)
+	}
+	return pools
+}
+
+// nodeName 返回节点名称，如 cu-v4-1
+func nodeName(key string, i int) string {
+	return fmt.Sprintf("%s-%d", key, i+1)
+}
@@ -0,0 +1,34 @@
+package render
+
+import (
+	"strings"
+
+	"controller/pkg/config"
+)
+
+// renderIPTxt 每行一个 IP，保持打分顺序
+func renderIPTxt(pools []pool, _ config.Proxy) (string, error) {
+	var b strings.Builder
+	for _, p := range pools {
+		for _, ip := range p.ips {
+			b.WriteString(ip + "\n")
+		}
+	}
+	return b.String(), nil
+}
+
+// renderCFST 生成 CloudflareST -f 参数使用的 IP 列表: 每行一个 IP，多条线路合并时去重
+func renderCFST(pools []pool, _ config.Proxy) (string, error) {
+	seen := make(map[string]bool)
+	var b strings.Builder
+	for _, p := range pools {
+		for _, ip := range p.ips {
+			if seen[ip] {
+				continue
+			}
+			seen[ip] = true
+			b.WriteString(ip + "\n")
+		}
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,38 @@
+package report
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// HistoryEntry 是 history.jsonl 中的一行: 一次运行各线路发布的 IP
+type HistoryEntry struct {
+	RunID string              `json:"run_id"`
+	At    string              `json:"at"`
+	Lines map[string][]string `json:"lines"`
+}
+
+// AppendHistory 在已有的 history.jsonl 内容末尾追加本次运行，只保留最近 size 行
+func AppendHistory(existing string, run Run, size int) (string, error) {
+	entry := HistoryEntry{RunID: run.ID, At: run.At.Format(time.RFC3339), Lines: make(map[string][]string)}
+	for _, l := range run.Lines {
+		entry.Lines[l.Key] = activeIPs(l.Result)
+	}
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return "", err
+	}
+
+	var rows []string
+	for _, row := range strings.Split(existing, "\n") {
+		if strings.TrimSpace(row) != "" {
+			rows = append(rows, row)
+		}
+	}
+	rows = append(rows, string(b))
+	if len(rows) > size {
+		rows = rows[len(rows)-size:]
+	}
+	return strings.Join(rows, "\n") + "\n", nil
+}
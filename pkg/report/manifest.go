@@ -0,0 +1,85 @@
+package report
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Manifest 是 manifest.json 的内容，供其他程序读取本次运行的概况
+type Manifest struct {
+	RunID       string         `json:"run_id"`
+	GeneratedAt string         `json:"generated_at"`
+	Devices     []Device       `json:"devices"`
+	Lines       []ManifestLine `json:"lines"`
+	// [新增] 本次写入的全部文件，下次运行只清理其中不再生成的文件
+	Files []string `json:"files"`
+}
+
+// ManifestLine 是 manifest.json 中的一条线路
+type ManifestLine struct {
+	Key        string     `json:"key"`
+	Group      string     `json:"group,omitempty"`
+	Operator   string     `json:"operator"`
+	IPVersion  string     `json:"ip_version"`
+	Record     string     `json:"record,omitempty"`
+	Thresholds Thresholds `json:"thresholds"`
+	Weights    Weights    `json:"weights"`
+	Candidates int        `json:"candidates"`
+	Active     []string   `json:"active"`
+	Fallback   string     `json:"fallback,omitempty"`
+	DNS        string     `json:"dns"`
+}
+
+// BuildManifest 生成 manifest.json
+func BuildManifest(run Run) (string, error) {
+	m := Manifest{
+		RunID:       run.ID,
+		GeneratedAt: run.At.Format(time.RFC3339),
+		Devices:     run.Devices,
+		Lines:       make([]ManifestLine, 0, len(run.Lines)),
+		Files:       run.Files,
+	}
+	if m.Devices == nil {
+		m.Devices = []Device{}
+	}
+	if m.Files == nil {
+		m.Files = []string{}
+	}
+	for _, l := range run.Lines {
+		m.Lines = append(m.Lines, ManifestLine{
+			Key:        l.Key,
+			Group:      l.Result.Group,
+			Operator:   l.Result.Operator,
+			IPVersion:  l.Result.IPVersion,
+			Record:     l.Record,
+			Thresholds: l.Thresholds,
+			Weights:    l.Weights,
+			Candidates: len(l.Result.Candidates),
+			Active:     activeIPs(l.Result),
+			Fallback:   l.Result.Fallback,
+			DNS:        l.DNS,
+		})
+	}
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// [新增] ManifestFiles 从上一次写入的 manifest.json 中读取由本程序写入的文件；
+// 早期版本的 manifest 没有 files 字段，此时只认定各线路的结果文件。内容无法解析时 ok 为 false
+func ManifestFiles(content string) (files []string, ok bool) {
+	var m Manifest
+	if content == "" || json.Unmarshal([]byte(content), &m) != nil {
+		return nil, false
+	}
+	if m.Files != nil {
+		return m.Files, true
+	}
+	for _, l := range m.Lines {
+		files = append(files, strings.ReplaceAll(l.Key, "/", ".")+".json")
+	}
+	return files, true
+}
@@ -0,0 +1,73 @@
+// Package report 生成结果 Gist 中的附加文件: summary.md、manifest.json 和 history.jsonl
+package report
+
+import (
+	"time"
+
+	"controller/pkg/models"
+)
+
+// 结果 Gist 中的附加文件名
+const (
+	SummaryFile  = "summary.md"
+	ManifestFile = "manifest.json"
+	HistoryFile  = "history.jsonl"
+)
+
+// 线路的 DNS 更新结果
+const (
+	DNSUpdated  = "updated"   // 已更新记录
+	DNSKept     = "kept"      // 没有可发布的 IP, 保留现有记录
+	DNSSkipped  = "skipped"   // 未配置记录集或服务商未启用
+	DNSFailed   = "failed"    // 调用服务商 API 失败
+	DNSNotTried = "not_tried" // 之前的线路失败或运行被取消, 未尝试更新
+)
+
+// Run 是生成附加文件所需的本次运行信息
+type Run struct {
+	ID      string
+	At      time.Time
+	Devices []Device
+	Lines   []Line   // 按 Key 排序
+	Files   []string // 本次写入结果 Gist 的文件名 (排序后)，记录在 manifest.json 中
+}
+
+// Device 是某台设备在本次运行中贡献的结果数量
+type Device struct {
+	GistID  string `json:"gist_id"`
+	Device  string `json:"device"`
+	Results int    `json:"results"`
+}
+
+// Line 是一条线路的优选结果、所用参数和 DNS 更新结果
+type Line struct {
+	Key        string
+	Record     string // 完整记录名, 如 cf.example.com
+	Name       string // 运营商显示名称
+	Result     models.LineResult
+	Thresholds Thresholds
+	Weights    Weights
+	DNS        string
+}
+
+// Thresholds 是该线路实际使用的筛选阈值
+type Thresholds struct {
+	MaxLatencyMs    int     `json:"max_latency_ms"`
+	MinDownloadMbps float64 `json:"min_download_mbps"`
+	MaxLossPct      float64 `json:"max_loss_pct"`
+}
+
+// Weights 是该线路实际使用的打分权重
+type Weights struct {
+	Latency float64 `json:"latency"`
+	Speed   float64 `json:"speed"`
+	Loss    float64 `json:"loss"`
+}
+
+func activeIPs(lr models.LineResult) []string {
+	ips := make([]string, 0, len(lr.Active))
+	for _, it := range lr.Active {
+		ips = append(ips, it.IP)
+	}
+	return ips
+}
@@ -0,0 +1,54 @@
+package report
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// BuildSummary 生成 summary.md: 每条线路一张表格，Active IP 排在前面并加粗标出
+func BuildSummary(run Run) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Cloudflare IP selection\n\n")
+	fmt.Fprintf(&b, "Updated at %s (run `%s`)\n\n", run.At.Format(time.RFC3339), run.ID)
+
+	if len(run.Devices) > 0 {
+		b.WriteString("| Device | Gist | Results |\n|---|---|---:|\n")
+		for _, d := range run.Devices {
+			fmt.Fprintf(&b, "| %s | `%s` | %d |\n", d.Device, d.GistID, d.Results)
+		}
+		b.WriteString("\n")
+	}
+
+	for _, l := range run.Lines {
+		fmt.Fprintf(&b, "## %s %s\n\n", l.Key, l.Name)
+		if l.Record != "" {
+			fmt.Fprintf(&b, "Record `%s`, DNS: **%s**", l.Record, l.DNS)
+		} else {
+			fmt.Fprintf(&b, "DNS: **%s**", l.DNS)
+		}
+		if l.Result.Fallback != "" {
+			fmt.Fprintf(&b, ", fallback: %s", l.Result.Fallback)
+		}
+		b.WriteString("\n\n")
+
+		if len(l.Result.Candidates) == 0 && len(l.Result.Active) == 0 {
+			b.WriteString("No qualifying IPs.\n\n")
+			continue
+		}
+		b.WriteString("| | IP | Score | Latency | Speed | Colo |\n|---|---|---:|---:|---:|---|\n")
+		active := make(map[string]bool, len(l.Result.Active))
+		for _, it := range l.Result.Active {
+			active[it.IP] = true
+			fmt.Fprintf(&b, "| ✅ | **%s** | %.2f | %dms | %.2fMbps | %s |\n", it.IP, it.Score, it.LatencyMs, it.DLMbps, it.Region)
+		}
+		for _, it := range l.Result.Candidates {
+			if active[it.IP] {
+				continue
+			}
+			fmt.Fprintf(&b, "| | %s | %.2f | %dms | %.2fMbps | %s |\n", it.IP, it.Score, it.LatencyMs, it.DLMbps, it.Region)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
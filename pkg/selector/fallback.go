@@ -0,0 +1,187 @@
+package selector
+
+import (
+	"fmt"
+	"math"
+
+	"controller/pkg/config"
+	"controller/pkg/models"
+	"controller/pkg/store"
+)
+
+type fallbackRequest struct {
+	line       config.Line
+	ipVersion  string
+	scoring    config.Scoring    // 已合并线路覆盖项
+	thresholds config.Thresholds // 已合并线路覆盖项
+	thin       models.LineResult // 正常筛选得到的 (不足 min_active 的) 结果
+	exclude    map[string]bool   // 不再选用的 IP (如未通过控制器探测)
+}
+
+// applyFallback 为合格 IP 不足的线路执行兜底策略，返回的 LineResult.Fallback 记录了实际采用的路径。
+// keep 策略 (默认) 返回空的 Active，UpdateAll 会跳过该线路，DNS 保持现有记录。
+func applyFallback(
+	req fallbackRequest,
+	rank func(key string, sc config.Scoring, th config.Thresholds) []models.DeviceResult,
+	primary map[string]models.LineResult,
+) models.LineResult {
+	ln, ipVersion := req.line, req.ipVersion
+	key := ln.Operator + "-" + ipVersion
+	fb := ln.Fallback
+	minActive := ln.MinActiveOrDefault()
+
+	switch fb.Policy {
+	case config.FallbackRelax:
+		factor := fb.RelaxFactorOrDefault()
+		for step := 1; step <= fb.RelaxStepsOrDefault(); step++ {
+			relaxed := relaxThresholds(req.thresholds, factor*float64(step))
+			lr := buildLineResult(ln, ipVersion, withoutIPs(rank(key, req.scoring, relaxed), req.exclude))
+			if len(lr.Active) >= minActive {
+				lr.Fallback = fmt.Sprintf("relax (step %d/%d: max_latency_ms=%d, min_download_mbps=%.2f, max_loss_pct=%.2f)",
+					step, fb.RelaxStepsOrDefault(), relaxed.MaxLatencyMs, relaxed.MinDownloadMbps, relaxed.MaxLossPct)
+				return lr
+			}
+		}
+
+	case config.FallbackBorrow:
+		for _, op := range fb.BorrowFrom {
+			src, ok := primary[op+"-"+ipVersion]
+			if !ok || len(src.Candidates) == 0 {
+				continue
+			}
+			lr := buildLineResult(ln, ipVersion, withoutIPs(toDeviceResults(src.Candidates), req.exclude))
+			if len(lr.Active) < minActive {
+				continue
+			}
+			lr.Fallback = "borrow from " + op
+			return lr
+		}
+
+	case config.FallbackStatic:
+		var items []models.DeviceResult
+		for _, ip := range fb.StaticIPs(ipVersion) {
+			if !req.exclude[ip] {
+				items = append(items, models.DeviceResult{IP: ip})
+			}
+		}
+		if len(items) > 0 {
+			lr := buildLineResult(ln, ipVersion, items)
+			lr.Fallback = "static"
+			return lr
+		}
+	}
+
+	reason := "keep"
+	if fb.Policy != "" && fb.Policy != config.FallbackKeep {
+		reason = fmt.Sprintf("keep (%s fallback found no IPs)", fb.Policy)
+	}
+	if n := len(req.thin.Active); n > 0 {
+		reason = fmt.Sprintf("%s, only %d/%d active IPs", reason, n, minActive)
+	}
+	// 保留不足数量的候选 IP 以便在结果 Gist 中查看，但不发布到 DNS
+	return models.LineResult{Operator: ln.Operator, IPVersion: ipVersion, Candidates: req.thin.Candidates, Fallback: reason}
+}
+
+// [新增] RefillGroups 在控制器探测之后重新检查 min_active: excluded 的 key 为探测中剔除了 IP 的线路 (models.LineKey)，
+// 值为该线路累计探测失败的 IP。Active 已不足 min_active 的线路会再次执行兜底策略 (不再选用失败的 IP)，
+// 返回重新兜底的线路结果，其中新选出的 IP 尚未经过探测
+func RefillGroups(
+	ag map[string][]models.DeviceResult,
+	groups []config.RecordGroup,
+	sc config.Scoring,
+	th config.Thresholds,
+	history []store.Measurement,
+	selected map[string]models.LineResult,
+	excluded map[string]map[string]bool,
+) map[string]models.LineResult {
+	rank := newRanker(ag, sc, history)
+	refilled := make(map[string]models.LineResult)
+	for _, g := range groups {
+		// borrow 只借用同组线路探测后剩余的候选 IP
+		primary := make(map[string]models.LineResult)
+		for _, ln := range g.Lines {
+			for _, ipVersion := range []string{"v4", "v6"} {
+				if lr, ok := selected[models.LineKey(g.ID, ln.Operator, ipVersion)]; ok {
+					primary[ln.Operator+"-"+ipVersion] = lr
+				}
+			}
+		}
+		for _, ln := range g.Lines {
+			for _, ipVersion := range []string{"v4", "v6"} {
+				key := models.LineKey(g.ID, ln.Operator, ipVersion)
+				lr, ok := selected[key]
+				if !ok || excluded[key] == nil || ln.RecordsetID(ipVersion) == "" || len(lr.Active) >= ln.MinActiveOrDefault() {
+					continue
+				}
+				lsc, lth := ln.Effective(ipVersion, sc, th)
+				out := applyFallback(fallbackRequest{
+					line: ln, ipVersion: ipVersion, scoring: lsc, thresholds: lth, thin: lr, exclude: excluded[key],
+				}, rank, primary)
+				out.Group = g.ID
+				out.Fallback += " after controller probe"
+				refilled[key] = out
+			}
+		}
+	}
+	return refilled
+}
+
+// [新增] KeepBelowMinActive 对探测后仍不足 min_active 的线路改为 keep: 清空 Active，DNS 保持现有记录。
+// keys 为需要检查的线路 (models.LineKey)
+func KeepBelowMinActive(groups []config.RecordGroup, selected map[string]models.LineResult, keys map[string]map[string]bool) {
+	for key := range keys {
+		lr, ok := selected[key]
+		if !ok || len(lr.Active) == 0 {
+			continue
+		}
+		group, operator, ipVersion := models.SplitLineKey(key)
+		var ln config.Line
+		for _, g := range groups {
+			if g.ID == group {
+				ln, _ = g.Line(operator)
+			}
+		}
+		if ln.RecordsetID(ipVersion) == "" || len(lr.Active) >= ln.MinActiveOrDefault() {
+			continue
+		}
+		lr.Fallback = fmt.Sprintf("keep (only %d/%d active IPs passed controller probe)", len(lr.Active), ln.MinActiveOrDefault())
+		lr.Active = nil
+		selected[key] = lr
+	}
+}
+
+// withoutIPs 过滤掉 exclude 中的 IP
+func withoutIPs(items []models.DeviceResult, exclude map[string]bool) []models.DeviceResult {
+	if len(exclude) == 0 {
+		return items
+	}
+	out := make([]models.DeviceResult, 0, len(items))
+	for _, it := range items {
+		if !exclude[it.IP] {
+			out = append(out, it)
+		}
+	}
+	return out
+}
+
+// relaxThresholds 按比例放宽阈值: 延迟和丢包上限提高，下载速度下限降低
+func relaxThresholds(th config.Thresholds, ratio float64) config.Thresholds {
+	th.MaxLatencyMs = int(math.Round(float64(th.MaxLatencyMs) * (1 + ratio)))
+	th.MinDownloadMbps = math.Max(0, th.MinDownloadMbps*(1-ratio))
+	th.MaxLossPct = th.MaxLossPct * (1 + ratio)
+	return th
+}
+
+func toDeviceResults(items []models.SelectedItem) []models.DeviceResult {
+	out := make([]models.DeviceResult, 0, len(items))
+	for _, it := range items {
+		out = append(out, models.DeviceResult{
+			IP:        it.IP,
+			Score:     it.Score,
+			LatencyMs: it.LatencyMs,
+			DLMbps:    it.DLMbps,
+			Region:    it.Region,
+		})
+	}
+	return out
+}
@@ -0,0 +1,211 @@
+package selector
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"controller/pkg/config"
+	"controller/pkg/models"
+)
+
+func activeIPs(lr models.LineResult) []string {
+	var ips []string
+	for _, it := range lr.Active {
+		ips = append(ips, it.IP)
+	}
+	return ips
+}
+
+func TestSelectTopFallback(t *testing.T) {
+	result := func(ip string, latency int) models.DeviceResult {
+		return models.DeviceResult{IP: ip, LatencyMs: latency, DLMbps: 50}
+	}
+	// ct-v4 的两个 IP 都合格，cu-v4 的 IP 按 cu 延迟决定是否合格
+	ag := func(cuLatency int) map[string][]models.DeviceResult {
+		return map[string][]models.DeviceResult{
+			"ct-v4": {result("1.1.1.1", 50), result("1.1.1.2", 60)},
+			"cu-v4": {result("2.2.2.1", cuLatency)},
+		}
+	}
+	cu := func(fb config.Fallback) config.Line {
+		return config.Line{Operator: "cu", ARecordsetID: "rs-cu", Cap: 2, Fallback: fb}
+	}
+	ct := config.Line{Operator: "ct", Cap: 2}
+
+	tests := []struct {
+		name         string
+		lines        []config.Line
+		ag           map[string][]models.DeviceResult
+		wantActive   []string
+		wantFallback string // 前缀
+	}{
+		{
+			name:       "qualified line needs no fallback",
+			lines:      []config.Line{cu(config.Fallback{Policy: config.FallbackRelax})},
+			ag:         ag(100),
+			wantActive: []string{"2.2.2.1"},
+		},
+		{
+			name:         "keep by default",
+			lines:        []config.Line{cu(config.Fallback{})},
+			ag:           ag(170),
+			wantFallback: "keep",
+		},
+		{
+			// 阈值 150: 第 1 步放宽到 180
+			name:         "relax first step",
+			lines:        []config.Line{cu(config.Fallback{Policy: config.FallbackRelax})},
+			ag:           ag(170),
+			wantActive:   []string{"2.2.2.1"},
+			wantFallback: "relax (step 1/3: max_latency_ms=180,",
+		},
+		{
+			name:         "relax later step",
+			lines:        []config.Line{cu(config.Fallback{Policy: config.FallbackRelax})},
+			ag:           ag(200),
+			wantActive:   []string{"2.2.2.1"},
+			wantFallback: "relax (step 2/3: max_latency_ms=210,",
+		},
+		{
+			name:         "relax exhausted",
+			lines:        []config.Line{cu(config.Fallback{Policy: config.FallbackRelax, RelaxSteps: 2, RelaxFactor: 0.1})},
+			ag:           ag(200),
+			wantFallback: "keep (relax fallback found no IPs)",
+		},
+		{
+			name:         "borrow skips lines without candidates",
+			lines:        []config.Line{ct, cu(config.Fallback{Policy: config.FallbackBorrow, BorrowFrom: []string{"cm", "ct"}})},
+			ag:           ag(170),
+			wantActive:   []string{"1.1.1.1", "1.1.1.2"},
+			wantFallback: "borrow from ct",
+		},
+		{
+			// ct 自身也在兜底，不能借用其兜底结果
+			name: "borrow ignores other fallback results",
+			lines: []config.Line{
+				{Operator: "ct", ARecordsetID: "rs-ct", Cap: 2, MinActive: 3, Fallback: config.Fallback{Policy: config.FallbackStatic, StaticV4: []string{"9.9.9.1", "9.9.9.2", "9.9.9.3"}}},
+				cu(config.Fallback{Policy: config.FallbackBorrow, BorrowFrom: []string{"ct"}}),
+			},
+			ag:           ag(170),
+			wantFallback: "keep (borrow fallback found no IPs)",
+		},
+		{
+			name:         "static",
+			lines:        []config.Line{cu(config.Fallback{Policy: config.FallbackStatic, StaticV4: []string{"9.9.9.9"}, StaticV6: []string{"::9"}})},
+			ag:           ag(170),
+			wantActive:   []string{"9.9.9.9"},
+			wantFallback: "static",
+		},
+		{
+			name:         "static without IPs for the version",
+			lines:        []config.Line{cu(config.Fallback{Policy: config.FallbackStatic, StaticV6: []string{"::9"}})},
+			ag:           ag(170),
+			wantFallback: "keep (static fallback found no IPs)",
+		},
+		{
+			name: "below min_active keeps records",
+			lines: []config.Line{
+				{Operator: "cu", ARecordsetID: "rs-cu", Cap: 2, MinActive: 2},
+			},
+			ag:           ag(100),
+			wantFallback: "keep, only 1/2 active IPs",
+		},
+		{
+			// 没有记录集的线路不发布 DNS，按正常结果返回
+			name:       "below min_active without recordset",
+			lines:      []config.Line{{Operator: "cu", Cap: 2, MinActive: 2}},
+			ag:         ag(100),
+			wantActive: []string{"2.2.2.1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selected := SelectTop(tt.ag, tt.lines, testScoring, testThresholds, nil)
+			lr, ok := selected["cu-v4"]
+			if !ok {
+				t.Fatal("cu-v4 missing from result")
+			}
+			if got := activeIPs(lr); !slices.Equal(got, tt.wantActive) {
+				t.Errorf("active = %v, want %v", got, tt.wantActive)
+			}
+			if tt.wantFallback == "" && lr.Fallback != "" || !strings.HasPrefix(lr.Fallback, tt.wantFallback) {
+				t.Errorf("fallback = %q, want prefix %q", lr.Fallback, tt.wantFallback)
+			}
+		})
+	}
+}
+
+func TestRefillGroups(t *testing.T) {
+	static := config.Fallback{Policy: config.FallbackStatic, StaticV4: []string{"9.9.9.8", "9.9.9.9"}}
+	groups := []config.RecordGroup{{
+		ID: "cdn",
+		Lines: []config.Line{
+			{Operator: "cu", ARecordsetID: "rs-cu", Cap: 2, Fallback: static},
+			{Operator: "ct", ARecordsetID: "rs-ct", Cap: 2, Fallback: static},
+		},
+	}}
+	selected := map[string]models.LineResult{
+		// cu 的 Active 全部探测失败
+		"cdn/cu-v4": {Group: "cdn", Operator: "cu", IPVersion: "v4"},
+		// ct 剔除一个 IP 后仍满足 min_active
+		"cdn/ct-v4": {Group: "cdn", Operator: "ct", IPVersion: "v4", Active: []models.SelectedItem{{IP: "1.1.1.2"}}},
+	}
+	excluded := map[string]map[string]bool{
+		"cdn/cu-v4": {"2.2.2.1": true, "9.9.9.8": true},
+		"cdn/ct-v4": {"1.1.1.1": true},
+	}
+
+	refilled := RefillGroups(nil, groups, testScoring, testThresholds, nil, selected, excluded)
+	if _, ok := refilled["cdn/ct-v4"]; ok {
+		t.Error("ct-v4 refilled although it still meets min_active")
+	}
+	lr, ok := refilled["cdn/cu-v4"]
+	if !ok {
+		t.Fatal("cu-v4 not refilled")
+	}
+	if got := activeIPs(lr); !slices.Equal(got, []string{"9.9.9.9"}) {
+		t.Errorf("active = %v, want [9.9.9.9]", got)
+	}
+	if lr.Group != "cdn" || lr.Fallback != "static after controller probe" {
+		t.Errorf("group = %q, fallback = %q", lr.Group, lr.Fallback)
+	}
+}
+
+func TestKeepBelowMinActive(t *testing.T) {
+	groups := []config.RecordGroup{{
+		Lines: []config.Line{
+			{Operator: "cu", ARecordsetID: "rs-cu", Cap: 3, MinActive: 2},
+			{Operator: "ct", ARecordsetID: "rs-ct", Cap: 3, MinActive: 1},
+			{Operator: "cm", Cap: 3, MinActive: 2},
+		},
+	}}
+	one := []models.SelectedItem{{IP: "1.1.1.1"}}
+	selected := map[string]models.LineResult{
+		"cu-v4": {Operator: "cu", IPVersion: "v4", Active: one, Candidates: one},
+		"ct-v4": {Operator: "ct", IPVersion: "v4", Active: one, Candidates: one},
+		"cm-v4": {Operator: "cm", IPVersion: "v4", Active: one, Candidates: one},
+	}
+	keys := map[string]map[string]bool{"cu-v4": nil, "ct-v4": nil, "cm-v4": nil}
+
+	KeepBelowMinActive(groups, selected, keys)
+
+	tests := []struct {
+		key          string
+		wantActive   int
+		wantFallback string
+	}{
+		{"cu-v4", 0, "keep (only 1/2 active IPs passed controller probe)"},
+		{"ct-v4", 1, ""},
+		{"cm-v4", 1, ""}, // 没有记录集
+	}
+	for _, tt := range tests {
+		lr := selected[tt.key]
+		if len(lr.Active) != tt.wantActive || lr.Fallback != tt.wantFallback {
+			t.Errorf("%s: active = %d, fallback = %q; want %d, %q", tt.key, len(lr.Active), lr.Fallback, tt.wantActive, tt.wantFallback)
+		}
+		if len(lr.Candidates) != 1 {
+			t.Errorf("%s: candidates dropped", tt.key)
+		}
+	}
+}
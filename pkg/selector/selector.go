@@ -5,9 +5,11 @@ package selector
 import (
 	"math"
 	"sort"
+	"time"
 
 	"controller/pkg/config"
 	"controller/pkg/models"
+	"controller/pkg/store"
 )
 
 func roundFloat(val float64, precision uint) float64 {
@@ -15,81 +17,239 @@ func roundFloat(val float64, precision uint) float64 {
 	return math.Round(val*ratio) / ratio
 }
 
+// [修改] history 为 nil 时仅使用本批次结果打分；否则在启用 EWMA 时按历史样本做时间衰减打分
+// [新增] 配置了记录集但合格 IP 数量不足 min_active 的线路，会按线路的 fallback 策略处理
+// [新增] 每条线路按 IP 版本使用合并了线路覆盖项后的阈值和权重
 func SelectTop(
 	ag map[string][]models.DeviceResult,
 	lines []config.Line,
 	sc config.Scoring,
 	th config.Thresholds,
+	history []store.Measurement,
 ) map[string]models.LineResult {
 
 	selectedResults := make(map[string]models.LineResult)
+	useEWMA := sc.EWMA.Enabled && history != nil
+	rank := newRanker(ag, sc, history)
 
+	var pending []fallbackRequest
 	for _, ln := range lines {
 		for _, ipVersion := range []string{"v4", "v6"} {
 			compositeKey := ln.Operator + "-" + ipVersion
-			list, ok := ag[compositeKey]
-			if !ok {
+			_, ok := ag[compositeKey]
+			if !ok && !useEWMA && ln.RecordsetID(ipVersion) == "" {
 				continue
 			}
 
-			var qualified []models.DeviceResult
-			for _, r := range list {
-				if r.LatencyMs > th.MaxLatencyMs ||
-					r.DLMbps < th.MinDownloadMbps ||
-					r.LossPct > th.MaxLossPct {
-					continue
-				}
-
-				score := sc.LatencyWeight*float64(r.LatencyMs) +
-					sc.SpeedWeight*r.DLMbps +
-					sc.LossWeight*r.LossPct
-				
-				r.Score = roundFloat(score, 2)
-				qualified = append(qualified, r)
+			lsc, lth := ln.Effective(ipVersion, sc, th)
+			lr := buildLineResult(ln, ipVersion, rank(compositeKey, lsc, lth))
+			switch {
+			case len(lr.Active) >= ln.MinActiveOrDefault():
+				selectedResults[compositeKey] = lr
+			case ln.RecordsetID(ipVersion) != "":
+				pending = append(pending, fallbackRequest{line: ln, ipVersion: ipVersion, scoring: lsc, thresholds: lth, thin: lr})
+			case len(lr.Candidates) > 0:
+				selectedResults[compositeKey] = lr
 			}
+		}
+	}
 
-			m := make(map[string]models.DeviceResult)
-			for _, r := range qualified {
-				prev, ok := m[r.IP]
-				if !ok || r.Score > prev.Score {
-					m[r.IP] = r
-				}
-			}
+	// 兜底结果单独收集后再合并，避免 borrow 借用到其他线路的兜底结果
+	fallbackResults := make(map[string]models.LineResult)
+	for _, req := range pending {
+		key := req.line.Operator + "-" + req.ipVersion
+		fallbackResults[key] = applyFallback(req, rank, selectedResults)
+	}
+	for key, lr := range fallbackResults {
+		selectedResults[key] = lr
+	}
+	return selectedResults
+}
 
-			uniq := make([]models.DeviceResult, 0, len(m))
-			for _, v := range m {
-				uniq = append(uniq, v)
-			}
-			sort.Slice(uniq, func(i, j int) bool {
-				return uniq[i].Score > uniq[j].Score
-			})
-			
-			// [修改] 移除 SourceDevice
-			var active, candidates []models.SelectedItem
-			dnsCap := ln.Cap
-			for i, r := range uniq {
-				item := models.SelectedItem{
-					IP:        r.IP,
-					Score:     r.Score,
-					LatencyMs: r.LatencyMs,
-					DLMbps:    r.DLMbps,
-					Region:    r.Region,
-				}
-				if i < dnsCap {
-					active = append(active, item)
-				}
-				candidates = append(candidates, item)
-			}
-			
-			if len(candidates) > 0 {
-				selectedResults[compositeKey] = models.LineResult{
-					Operator:   ln.Operator,
-					IPVersion:  ipVersion, // [新增]
-					Active:     active,
-					Candidates: candidates,
-				}
-			}
+// newRanker 返回按 "运营商-IP版本" 对结果筛选打分的函数；启用 EWMA 时使用历史样本
+func newRanker(
+	ag map[string][]models.DeviceResult,
+	sc config.Scoring,
+	history []store.Measurement,
+) func(key string, sc config.Scoring, th config.Thresholds) []models.DeviceResult {
+	useEWMA := sc.EWMA.Enabled && history != nil
+	samplesByLine := groupByLine(history)
+	now := time.Now()
+	return func(key string, sc config.Scoring, th config.Thresholds) []models.DeviceResult {
+		if useEWMA {
+			return scoreEWMA(samplesByLine[key], ag[key], sc, th, now)
 		}
+		return scoreLatest(ag[key], sc, th)
 	}
-	return selectedResults
-}
\ No newline at end of file
+}
+
+// [新增] SelectGroups 对每个记录组分别执行 SelectTop (共用同一批聚合结果和历史样本)，
+// 返回以 models.LineKey 为 key 的线路结果
+func SelectGroups(
+	ag map[string][]models.DeviceResult,
+	groups []config.RecordGroup,
+	sc config.Scoring,
+	th config.Thresholds,
+	history []store.Measurement,
+) map[string]models.LineResult {
+	selected := make(map[string]models.LineResult)
+	for _, g := range groups {
+		for _, lr := range SelectTop(ag, g.Lines, sc, th, history) {
+			lr.Group = g.ID
+			selected[models.LineKey(g.ID, lr.Operator, lr.IPVersion)] = lr
+		}
+	}
+	return selected
+}
+
+// buildLineResult 按分数排序，并按 cap 和相对分差截断拆分出待发布的 Active 列表
+func buildLineResult(ln config.Line, ipVersion string, uniq []models.DeviceResult) models.LineResult {
+	sort.Slice(uniq, func(i, j int) bool {
+		return uniq[i].Score > uniq[j].Score
+	})
+
+	// [修改] 移除 SourceDevice
+	var active, candidates []models.SelectedItem
+	dnsCap := ln.CapFor(ipVersion)
+	for i, r := range uniq {
+		item := models.SelectedItem{
+			IP:        r.IP,
+			Score:     r.Score,
+			LatencyMs: r.LatencyMs,
+			DLMbps:    r.DLMbps,
+			Region:    r.Region,
+		}
+		if i < dnsCap && WithinScoreGap(uniq[0].Score, r.Score, ln.MaxScoreGap) {
+			active = append(active, item)
+		}
+		candidates = append(candidates, item)
+	}
+	return models.LineResult{
+		Operator:   ln.Operator,
+		IPVersion:  ipVersion, // [新增]
+		Active:     active,
+		Candidates: candidates,
+	}
+}
+
+// WithinScoreGap 判断分数与第一名的差距是否在 max_score_gap 允许的相对范围内
+func WithinScoreGap(leader, score, maxGap float64) bool {
+	if maxGap <= 0 {
+		return true
+	}
+	return leader-score <= math.Abs(leader)*maxGap
+}
+
+func qualifies(r models.DeviceResult, th config.Thresholds) bool {
+	return r.LatencyMs <= th.MaxLatencyMs &&
+		r.DLMbps >= th.MinDownloadMbps &&
+		r.LossPct <= th.MaxLossPct
+}
+
+func score(latencyMs, dlMbps, lossPct float64, sc config.Scoring) float64 {
+	return sc.LatencyWeight*latencyMs +
+		sc.SpeedWeight*dlMbps +
+		sc.LossWeight*lossPct
+}
+
+// scoreLatest 仅基于本批次结果打分，同一 IP 取各设备中的最高分
+func scoreLatest(list []models.DeviceResult, sc config.Scoring, th config.Thresholds) []models.DeviceResult {
+	m := make(map[string]models.DeviceResult)
+	for _, r := range list {
+		if !qualifies(r, th) {
+			continue
+		}
+		r.Score = roundFloat(score(float64(r.LatencyMs), r.DLMbps, r.LossPct, sc), 2)
+		prev, ok := m[r.IP]
+		if !ok || r.Score > prev.Score {
+			m[r.IP] = r
+		}
+	}
+
+	uniq := make([]models.DeviceResult, 0, len(m))
+	for _, v := range m {
+		uniq = append(uniq, v)
+	}
+	return uniq
+}
+
+// scoreEWMA 对每个 IP 的历史样本按半衰期做指数加权平均，再用平均后的指标做阈值筛选和打分。
+// 多次运行都表现稳定的 IP 会优于只有一次好成绩的 IP。
+// 本批次未出现的 IP 仍按历史参与排序，并可按缺席时长扣分。
+func scoreEWMA(samples []store.Measurement, current []models.DeviceResult, sc config.Scoring, th config.Thresholds, now time.Time) []models.DeviceResult {
+	type acc struct {
+		weight, latency, dl, loss float64
+		latest                    store.Measurement
+	}
+
+	halfLife := sc.EWMA.HalfLife()
+	byIP := make(map[string]*acc)
+	for _, s := range samples {
+		age := now.Sub(s.At)
+		if age < 0 {
+			age = 0
+		}
+		w := math.Pow(0.5, float64(age)/float64(halfLife))
+		a, ok := byIP[s.Result.IP]
+		if !ok {
+			a = &acc{}
+			byIP[s.Result.IP] = a
+		}
+		a.weight += w
+		a.latency += w * float64(s.Result.LatencyMs)
+		a.dl += w * s.Result.DLMbps
+		a.loss += w * s.Result.LossPct
+		if !s.At.Before(a.latest.At) {
+			a.latest = s
+		}
+	}
+
+	// 历史库中尚未写入的本批次结果 (例如历史写入失败) 以当前时间补入
+	seen := make(map[string]bool)
+	for _, r := range current {
+		seen[r.IP] = true
+		if _, ok := byIP[r.IP]; ok {
+			continue
+		}
+		byIP[r.IP] = &acc{
+			weight:  1,
+			latency: float64(r.LatencyMs),
+			dl:      r.DLMbps,
+			loss:    r.LossPct,
+			latest:  store.Measurement{At: now, Result: r},
+		}
+	}
+
+	uniq := make([]models.DeviceResult, 0, len(byIP))
+	for ip, a := range byIP {
+		if a.weight == 0 {
+			continue
+		}
+		r := a.latest.Result
+		r.IP = ip
+		r.LatencyMs = int(math.Round(a.latency / a.weight))
+		r.DLMbps = roundFloat(a.dl/a.weight, 2)
+		r.LossPct = roundFloat(a.loss/a.weight, 2)
+		if !qualifies(r, th) {
+			continue
+		}
+		sv := score(a.latency/a.weight, a.dl/a.weight, a.loss/a.weight, sc)
+		if !seen[ip] && sc.EWMA.MissingPenalty > 0 {
+			absent := now.Sub(a.latest.At)
+			sv -= sc.EWMA.MissingPenalty * float64(absent) / float64(halfLife)
+		}
+		r.Score = roundFloat(sv, 2)
+		uniq = append(uniq, r)
+	}
+	return uniq
+}
+
+// groupByLine 将历史样本按 "运营商-IP版本" 分组
+func groupByLine(history []store.Measurement) map[string][]store.Measurement {
+	out := make(map[string][]store.Measurement)
+	for _, m := range history {
+		key := m.Result.Operator + "-" + m.IPVersion
+		out[key] = append(out[key], m)
+	}
+	return out
+}
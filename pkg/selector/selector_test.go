@@ -0,0 +1,238 @@
+package selector
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"controller/pkg/config"
+	"controller/pkg/models"
+	"controller/pkg/store"
+)
+
+// 延迟每毫秒扣 1 分，下载每 Mbps 加 1 分，丢包每 1% 扣 10 分
+var testScoring = config.Scoring{LatencyWeight: -1, SpeedWeight: 1, LossWeight: -10}
+
+var testThresholds = config.Thresholds{MaxLatencyMs: 150, MinDownloadMbps: 10, MaxLossPct: 5}
+
+func TestScoreEWMA(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	sample := func(ip string, age time.Duration, latency int, dl float64) store.Measurement {
+		return store.Measurement{
+			At:        now.Add(-age),
+			IPVersion: "v4",
+			Result:    models.DeviceResult{Operator: "cu", IP: ip, LatencyMs: latency, DLMbps: dl},
+		}
+	}
+	current := func(ip string, latency int, dl float64) models.DeviceResult {
+		return models.DeviceResult{Operator: "cu", IP: ip, LatencyMs: latency, DLMbps: dl}
+	}
+
+	tests := []struct {
+		name    string
+		samples []store.Measurement
+		current []models.DeviceResult
+		penalty float64
+		want    map[string]float64 // IP -> 分数, 不在其中的 IP 应被阈值过滤
+	}{
+		{
+			// 两个半衰期前的样本权重为 0.25: 延迟 (0.25*100 + 20) / 1.25 = 36
+			name:    "older samples decay",
+			samples: []store.Measurement{sample("a", 2*time.Hour, 100, 50), sample("a", 0, 20, 50)},
+			current: []models.DeviceResult{current("a", 20, 50)},
+			want:    map[string]float64{"a": 14},
+		},
+		{
+			name:    "missing penalty per half-life of absence",
+			samples: []store.Measurement{sample("b", 2*time.Hour, 20, 50)},
+			penalty: 5,
+			want:    map[string]float64{"b": 20},
+		},
+		{
+			name:    "no penalty when disabled",
+			samples: []store.Measurement{sample("b", 2*time.Hour, 20, 50)},
+			want:    map[string]float64{"b": 30},
+		},
+		{
+			name:    "no penalty when present in current batch",
+			samples: []store.Measurement{sample("b", 2*time.Hour, 20, 50)},
+			current: []models.DeviceResult{current("b", 20, 50)},
+			penalty: 5,
+			want:    map[string]float64{"b": 30},
+		},
+		{
+			name:    "current result missing from history",
+			current: []models.DeviceResult{current("c", 30, 50)},
+			want:    map[string]float64{"c": 20},
+		},
+		{
+			// 最近一次样本合格，但平均延迟 160 超过阈值
+			name:    "thresholds apply to averaged metrics",
+			samples: []store.Measurement{sample("d", 0, 20, 50), sample("d", 0, 300, 50)},
+			current: []models.DeviceResult{current("d", 20, 50)},
+			want:    map[string]float64{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc := testScoring
+			sc.EWMA = config.EWMA{Enabled: true, HalfLifeMinutes: 60, MissingPenalty: tt.penalty}
+			got := make(map[string]float64)
+			for _, r := range scoreEWMA(tt.samples, tt.current, sc, testThresholds, now) {
+				got[r.IP] = r.Score
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("scores = %v, want %v", got, tt.want)
+			}
+			for ip, want := range tt.want {
+				if s, ok := got[ip]; !ok || s != want {
+					t.Errorf("score[%s] = %v, want %v", ip, s, want)
+				}
+			}
+		})
+	}
+}
+
+func TestBuildLineResult(t *testing.T) {
+	tests := []struct {
+		name       string
+		line       config.Line
+		ipVersion  string
+		scores     []float64
+		wantActive []string
+	}{
+		{
+			name:       "cap limits active",
+			line:       config.Line{Cap: 2},
+			ipVersion:  "v4",
+			scores:     []float64{100, 95, 80, 40},
+			wantActive: []string{"ip0", "ip1"},
+		},
+		{
+			name:       "cap_v4 overrides cap",
+			line:       config.Line{Cap: 1, CapV4: 3},
+			ipVersion:  "v4",
+			scores:     []float64{100, 95, 80, 40},
+			wantActive: []string{"ip0", "ip1", "ip2"},
+		},
+		{
+			name:       "cap_v6 does not apply to v4",
+			line:       config.Line{Cap: 2, CapV6: 4},
+			ipVersion:  "v4",
+			scores:     []float64{100, 95, 80, 40},
+			wantActive: []string{"ip0", "ip1"},
+		},
+		{
+			name:       "cap_v6 overrides cap",
+			line:       config.Line{Cap: 2, CapV6: 4},
+			ipVersion:  "v6",
+			scores:     []float64{100, 95, 80, 40},
+			wantActive: []string{"ip0", "ip1", "ip2", "ip3"},
+		},
+		{
+			name:       "max_score_gap cuts far behind IPs",
+			line:       config.Line{Cap: 4, MaxScoreGap: 0.1},
+			ipVersion:  "v4",
+			scores:     []float64{100, 95, 80, 40},
+			wantActive: []string{"ip0", "ip1"},
+		},
+		{
+			name:       "max_score_gap boundary is inclusive",
+			line:       config.Line{Cap: 4, MaxScoreGap: 0.2},
+			ipVersion:  "v4",
+			scores:     []float64{100, 95, 80, 40},
+			wantActive: []string{"ip0", "ip1", "ip2"},
+		},
+		{
+			// 分差按第一名分数的绝对值计算
+			name:       "max_score_gap with negative scores",
+			line:       config.Line{Cap: 4, MaxScoreGap: 0.5},
+			ipVersion:  "v4",
+			scores:     []float64{-10, -12, -20},
+			wantActive: []string{"ip0", "ip1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var uniq []models.DeviceResult
+			// 倒序传入，验证按分数排序
+			for i := len(tt.scores) - 1; i >= 0; i-- {
+				uniq = append(uniq, models.DeviceResult{IP: "ip" + string(rune('0'+i)), Score: tt.scores[i]})
+			}
+			lr := buildLineResult(tt.line, tt.ipVersion, uniq)
+			if got := activeIPs(lr); !slices.Equal(got, tt.wantActive) {
+				t.Errorf("active = %v, want %v", got, tt.wantActive)
+			}
+			if len(lr.Candidates) != len(tt.scores) {
+				t.Errorf("candidates = %d, want %d", len(lr.Candidates), len(tt.scores))
+			}
+		})
+	}
+}
+
+func TestSelectTopLineOverrides(t *testing.T) {
+	intp := func(v int) *int { return &v }
+	floatp := func(v float64) *float64 { return &v }
+	ag := map[string][]models.DeviceResult{
+		"cu-v4": {{IP: "1.1.1.1", LatencyMs: 100, DLMbps: 50}},
+		"cu-v6": {{IP: "::1", LatencyMs: 200, DLMbps: 50}},
+	}
+
+	tests := []struct {
+		name      string
+		line      config.Line
+		key       string
+		wantScore float64 // 为 0 表示该线路应没有合格 IP
+	}{
+		{
+			name:      "global values",
+			line:      config.Line{Operator: "cu", Cap: 1},
+			key:       "cu-v4",
+			wantScore: -50,
+		},
+		{
+			name: "global threshold rejects",
+			line: config.Line{Operator: "cu", Cap: 1},
+			key:  "cu-v6",
+		},
+		{
+			// 只覆盖延迟阈值和延迟权重，速度权重继承全局值
+			name: "v6 override",
+			line: config.Line{Operator: "cu", Cap: 1, V6: config.LineOverride{
+				Thresholds: config.ThresholdsOverride{MaxLatencyMs: intp(300)},
+				Scoring:    config.ScoringOverride{LatencyWeight: floatp(0)},
+			}},
+			key:       "cu-v6",
+			wantScore: 50,
+		},
+		{
+			name: "v6 override does not apply to v4",
+			line: config.Line{Operator: "cu", Cap: 1, V6: config.LineOverride{
+				Scoring: config.ScoringOverride{LatencyWeight: floatp(0)},
+			}},
+			key:       "cu-v4",
+			wantScore: -50,
+		},
+		{
+			name: "v4 threshold override rejects",
+			line: config.Line{Operator: "cu", Cap: 1, V4: config.LineOverride{
+				Thresholds: config.ThresholdsOverride{MinDownloadMbps: floatp(60)},
+			}},
+			key: "cu-v4",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lr := SelectTop(ag, []config.Line{tt.line}, testScoring, testThresholds, nil)[tt.key]
+			if tt.wantScore == 0 {
+				if len(lr.Active) != 0 {
+					t.Fatalf("active = %v, want none", activeIPs(lr))
+				}
+				return
+			}
+			if len(lr.Active) != 1 || lr.Active[0].Score != tt.wantScore {
+				t.Errorf("active = %+v, want one IP with score %v", lr.Active, tt.wantScore)
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+// Package state 保存控制器在多次运行之间需要保留的本地状态 (<state-dir>/state.json)
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"controller/pkg/gist"
+	"controller/pkg/models"
+)
+
+const (
+	FileName = "state.json"
+	// SchemaVersion 是当前的状态文件格式版本，修改 State 的结构时递增并在 migrations 中补充升级步骤
+	SchemaVersion = 1
+
+	legacyGistIDFile = "result_gist_id.txt"
+)
+
+// State 是状态文件的内容
+type State struct {
+	Version      int                       `json:"version"`
+	ResultGistID string                    `json:"result_gist_id,omitempty"` // 自动创建的结果 Gist
+	Lines        map[string]Line           `json:"lines,omitempty"`          // 各线路最近一次发布到 DNS 的结果
+	ETags        map[string]gist.GistCache `json:"etags,omitempty"`          // 设备 Gist 元数据的 ETag 缓存
+	LastPublish  Publish                   `json:"last_publish,omitzero"`
+	LastSuccess  time.Time                 `json:"last_success,omitzero"` // 最近一次成功完成的运行
+}
+
+// Line 记录某条线路最近一次发布的 IP 及其对应的服务商记录
+type Line struct {
+	Provider    string                `json:"provider"`
+	ZoneID      string                `json:"zone_id"`
+	RecordsetID string                `json:"recordset_id"`
+	RecordName  string                `json:"record_name"`
+	RecordType  string                `json:"record_type"`
+	Active      []models.SelectedItem `json:"active"`
+	PublishedAt time.Time             `json:"published_at"`
+}
+
+// Publish 是最近一次写入结果 Gist 的内容摘要，重启后用于跳过没有变化的写入
+type Publish struct {
+	GistID     string    `json:"gist_id"`
+	Content    string    `json:"content_digest"`
+	Candidates string    `json:"candidates_digest"`
+	At         time.Time `json:"at"`
+}
+
+// migrations[v] 将版本 v 的状态升级到 v+1
+var migrations = map[int]func(*State){}
+
+// Store 是状态文件的读写入口，每次修改后整体写回
+type Store struct {
+	path     string
+	readOnly bool // 只在内存中修改，不写回文件
+	mu       sync.Mutex
+	st       State
+}
+
+// Open 读取 dir 下的状态文件，不存在时新建。
+// 首次创建时从 legacyDirs 中查找旧版本的 result_gist_id.txt 并迁移到状态文件。
+func Open(dir string, legacyDirs ...string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create state dir %s: %w", dir, err)
+	}
+	return open(dir, false, legacyDirs)
+}
+
+// OpenReadOnly 与 Open 相同，但不创建目录和文件、不删除旧文件，Update 只修改内存中的状态 (用于 --dry-run)
+func OpenReadOnly(dir string, legacyDirs ...string) (*Store, error) {
+	return open(dir, true, legacyDirs)
+}
+
+func open(dir string, readOnly bool, legacyDirs []string) (*Store, error) {
+	s := &Store{path: filepath.Join(dir, FileName), readOnly: readOnly}
+
+	b, err := os.ReadFile(s.path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		s.st = State{Version: SchemaVersion}
+		migrateLegacy(&s.st, append([]string{dir}, legacyDirs...), !readOnly)
+		if err := s.save(); err != nil {
+			return nil, err
+		}
+		return s, nil
+	case err != nil:
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	if err := json.Unmarshal(b, &s.st); err != nil {
+		return nil, fmt.Errorf("failed to parse state file %s: %w", s.path, err)
+	}
+	if s.st.Version > SchemaVersion {
+		return nil, fmt.Errorf("state file %s has version %d, newer than supported version %d", s.path, s.st.Version, SchemaVersion)
+	}
+	if s.st.Version < SchemaVersion {
+		from := s.st.Version
+		for s.st.Version < SchemaVersion {
+			if m := migrations[s.st.Version]; m != nil {
+				m(&s.st)
+			}
+			s.st.Version++
+		}
+		if err := s.save(); err != nil {
+			return nil, err
+		}
+		if !readOnly {
+			slog.Info("state file upgraded", "path", s.path, "from_version", from, "version", s.st.Version)
+		}
+	}
+	return s, nil
+}
+
+// migrateLegacy 读取旧版本保存的 result_gist_id.txt，remove 为 true 时迁移后删除旧文件
+func migrateLegacy(st *State, dirs []string, remove bool) {
+	for _, dir := range dirs {
+		path := filepath.Join(dir, legacyGistIDFile)
+		b, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		id := strings.TrimSpace(string(b))
+		if id == "" {
+			continue
+		}
+		st.ResultGistID = id
+		if !remove {
+			return
+		}
+		slog.Info("migrated result gist ID to state file", "from", path, "gist_id", id)
+		if err := os.Remove(path); err != nil {
+			slog.Warn("failed to remove legacy result gist ID file", "path", path, "error", err)
+		}
+		return
+	}
+}
+
+// Path 返回状态文件路径
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Get 返回当前状态的副本
+func (s *Store) Get() State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.st
+	st.Lines = maps.Clone(s.st.Lines)
+	st.ETags = maps.Clone(s.st.ETags)
+	return st
+}
+
+// Update 修改状态并立即写回文件，写入失败时内存中的状态保持不变
+func (s *Store) Update(fn func(*State)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old := s.st
+	s.st.Lines = maps.Clone(old.Lines)
+	s.st.ETags = maps.Clone(old.ETags)
+	fn(&s.st)
+	if err := s.save(); err != nil {
+		s.st = old
+		return err
+	}
+	return nil
+}
+
+func (s *Store) save() error {
+	if s.readOnly {
+		return nil
+	}
+	b, err := json.MarshalIndent(s.st, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := WriteFile(s.path, b, 0600); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	return nil
+}
+
+// WriteFile 先写入同目录下的临时文件并同步到磁盘，再重命名为 path，
+// 进程中途退出时不会留下不完整的文件
+func WriteFile(path string, data []byte, perm fs.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,178 @@
+package status
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"controller/pkg/models"
+)
+
+// 运行结果
+const (
+	OutcomeSuccess   = "success"    // 任务正常完成
+	OutcomeNoResults = "no_results" // 时间范围内没有可用的设备结果
+	OutcomeFailed    = "failed"     // 任务中途失败
+	OutcomeStandby   = "standby"    // [新增] 非 leader 副本完成了优选, 未更新 DNS 和结果 Gist
+)
+
+// 选主角色，未启用选主时为空
+const (
+	RoleLeader   = "leader"
+	RoleFollower = "follower"
+)
+
+// Run 描述一次任务运行
+type Run struct {
+	StartedAt    time.Time `json:"started_at"`
+	FinishedAt   time.Time `json:"finished_at,omitempty"`
+	Outcome      string    `json:"outcome"`
+	Error        string    `json:"error,omitempty"`
+	UpdatedLines int       `json:"updated_lines"`
+}
+
+// Device 记录某台测速设备最近一次被采集的情况
+type Device struct {
+	Device      string    `json:"device"`
+	GistID      string    `json:"gist_id"`
+	LastSeen    time.Time `json:"last_seen"`
+	ResultCount int       `json:"result_count"`
+}
+
+// PendingUpload 描述一次写入失败、等待下次运行重试的结果 Gist 上传
+type PendingUpload struct {
+	GistID    string    `json:"gist_id,omitempty"`
+	Files     int       `json:"files"`
+	Deletions int       `json:"deletions"`
+	SavedAt   time.Time `json:"saved_at"`
+	Attempts  int       `json:"attempts"`
+	Error     string    `json:"error"`
+}
+
+// Registry 保存控制器的运行时状态，供 HTTP API 等只读查询
+type Registry struct {
+	mu      sync.RWMutex
+	running bool
+	lastRun *Run
+	lines   map[string]models.LineResult
+	devices map[string]Device // key 为 "Gist ID/设备名"，不同 Gist 中的同名设备分别记录
+	role    func() string     // [新增] 返回当前选主角色
+	pending *PendingUpload    // [新增] 待重试的结果 Gist 上传
+}
+
+func New() *Registry {
+	return &Registry{
+		lines:   make(map[string]models.LineResult),
+		devices: make(map[string]Device),
+	}
+}
+
+func (r *Registry) StartRun() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.running = true
+}
+
+func (r *Registry) FinishRun(run Run) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.running = false
+	r.lastRun = &run
+}
+
+// SetLines 记录最近一次优选出的各线路结果
+func (r *Registry) SetLines(selected map[string]models.LineResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lines = make(map[string]models.LineResult, len(selected))
+	for key, lr := range selected {
+		r.lines[key] = lr
+	}
+}
+
+// SeenDevices 按设备统计某个 Gist 本次贡献的结果数
+func (r *Registry) SeenDevices(gistID string, at time.Time, drs []models.DeviceResult) {
+	counts := make(map[string]int)
+	for _, d := range drs {
+		counts[d.Device]++
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for name, n := range counts {
+		r.devices[gistID+"/"+name] = Device{Device: name, GistID: gistID, LastSeen: at, ResultCount: n}
+	}
+}
+
+// SetRole 设置查询当前选主角色的函数
+func (r *Registry) SetRole(role func() string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.role = role
+}
+
+// Role 返回当前选主角色，未启用选主时为空
+func (r *Registry) Role() string {
+	r.mu.RLock()
+	role := r.role
+	r.mu.RUnlock()
+	if role == nil {
+		return ""
+	}
+	return role()
+}
+
+// LastRun 返回最近一次完成的运行 (没有时为 nil) 以及当前是否有任务正在运行
+func (r *Registry) LastRun() (*Run, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.lastRun == nil {
+		return nil, r.running
+	}
+	run := *r.lastRun
+	return &run, r.running
+}
+
+func (r *Registry) Lines() map[string]models.LineResult {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make(map[string]models.LineResult, len(r.lines))
+	for key, lr := range r.lines {
+		out[key] = lr
+	}
+	return out
+}
+
+// Devices 返回按设备名和 Gist ID 排序的设备列表
+func (r *Registry) Devices() []Device {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]Device, 0, len(r.devices))
+	for _, d := range r.devices {
+		out = append(out, d)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Device != out[j].Device {
+			return out[i].Device < out[j].Device
+		}
+		return out[i].GistID < out[j].GistID
+	})
+	return out
+}
+
+// SetPendingUpload 记录待重试的结果 Gist 上传，nil 表示没有
+func (r *Registry) SetPendingUpload(p *PendingUpload) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pending = p
+}
+
+// PendingUpload 返回待重试的结果 Gist 上传，没有时为 nil
+func (r *Registry) PendingUpload() *PendingUpload {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.pending == nil {
+		return nil
+	}
+	p := *r.pending
+	return &p
+}
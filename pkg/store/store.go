@@ -0,0 +1,268 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"controller/pkg/models"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	bucketMeasurements = []byte("measurements")
+	bucketSelections   = []byte("selections")
+	bucketDNSChanges   = []byte("dns_changes")
+)
+
+// Measurement 是一条被采集入库的设备测速记录
+type Measurement struct {
+	At        time.Time           `json:"at"`
+	GistID    string              `json:"gist_id"`
+	IPVersion string              `json:"ip_version"`
+	Result    models.DeviceResult `json:"result"`
+}
+
+// Selection 记录某条线路 (如 cu-v4) 在一次运行中的优选结果
+type Selection struct {
+	At         time.Time             `json:"at"`
+	Line       string                `json:"line"`
+	Active     []models.SelectedItem `json:"active"`
+	Candidates []models.SelectedItem `json:"candidates"`
+}
+
+// DNSChange 记录一次 DNS 更新操作及其结果
+type DNSChange struct {
+	At          time.Time `json:"at"`
+	Provider    string    `json:"provider"`
+	Line        string    `json:"line"`
+	ZoneID      string    `json:"zone_id"`
+	RecordsetID string    `json:"recordset_id"`
+	RecordName  string    `json:"record_name"`
+	RecordType  string    `json:"record_type"`
+	IPs         []string  `json:"ips"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// Store 是基于 bbolt 的本地历史数据存储，按时间顺序保存测速、优选和 DNS 变更记录
+type Store struct {
+	db *bolt.DB
+}
+
+// Open 打开 (或创建) 位于 path 的历史数据库
+func Open(path string) (*Store, error) {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create history directory %s: %w", dir, err)
+		}
+	}
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history store %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{bucketMeasurements, bucketSelections, bucketDNSChanges} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize history store %s: %w", path, err)
+	}
+	return &Store{db: db}, nil
+}
+
+// OpenReadOnly 以只读方式打开已存在的历史数据库 (供 show lines 和 --dry-run 使用)，不会创建文件。
+// 服务运行中时数据库被独占锁定，此时在 timeout 后返回错误
+func OpenReadOnly(path string, timeout time.Duration) (*Store, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("history store %s is not available: %w", path, err)
+	}
+	db, err := bolt.Open(path, 0644, &bolt.Options{ReadOnly: true, Timeout: timeout})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history store %s read-only: %w", path, err)
+	}
+	return &Store{db: db}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// RecordMeasurements 保存某个设备 Gist 的一批测速结果，at 为该批结果的上传时间 (Gist 的 updated_at)
+func (s *Store) RecordMeasurements(at time.Time, gistID string, drs []models.DeviceResult) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketMeasurements)
+		for _, d := range drs {
+			m := Measurement{At: at, GistID: gistID, IPVersion: d.IPVersion, Result: d}
+			if err := put(b, at, m); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// RecordSelections 保存每条线路的优选结果
+func (s *Store) RecordSelections(at time.Time, selected map[string]models.LineResult) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketSelections)
+		for key, ln := range selected {
+			sel := Selection{At: at, Line: key, Active: ln.Active, Candidates: ln.Candidates}
+			if err := put(b, at, sel); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// RecordDNSChange 保存一次 DNS 更新操作
+func (s *Store) RecordDNSChange(c DNSChange) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return put(tx.Bucket(bucketDNSChanges), c.At, c)
+	})
+}
+
+// Measurements 返回 since 之后入库的测速记录，按时间升序
+func (s *Store) Measurements(since time.Time) ([]Measurement, error) {
+	var out []Measurement
+	err := s.scan(bucketMeasurements, since, func(v []byte) error {
+		var m Measurement
+		if err := json.Unmarshal(v, &m); err != nil {
+			return err
+		}
+		out = append(out, m)
+		return nil
+	})
+	return out, err
+}
+
+// Selections 返回 since 之后的优选记录，按时间升序
+func (s *Store) Selections(since time.Time) ([]Selection, error) {
+	var out []Selection
+	err := s.scan(bucketSelections, since, func(v []byte) error {
+		var sel Selection
+		if err := json.Unmarshal(v, &sel); err != nil {
+			return err
+		}
+		out = append(out, sel)
+		return nil
+	})
+	return out, err
+}
+
+// LatestSelections 从最新的记录向前查找 lines 中每条线路最近一次的优选结果，全部找到后即停止
+func (s *Store) LatestSelections(lines []string) (map[string]Selection, error) {
+	want := make(map[string]bool, len(lines))
+	for _, l := range lines {
+		want[l] = true
+	}
+	out := make(map[string]Selection, len(lines))
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketSelections)
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, v := c.Last(); k != nil && len(out) < len(want); k, v = c.Prev() {
+			var sel Selection
+			if err := json.Unmarshal(v, &sel); err != nil {
+				return err
+			}
+			if _, seen := out[sel.Line]; want[sel.Line] && !seen {
+				out[sel.Line] = sel
+			}
+		}
+		return nil
+	})
+	return out, err
+}
+
+// DNSChanges 返回 since 之后的 DNS 变更记录，按时间升序
+func (s *Store) DNSChanges(since time.Time) ([]DNSChange, error) {
+	var out []DNSChange
+	err := s.scan(bucketDNSChanges, since, func(v []byte) error {
+		var c DNSChange
+		if err := json.Unmarshal(v, &c); err != nil {
+			return err
+		}
+		out = append(out, c)
+		return nil
+	})
+	return out, err
+}
+
+// Prune 删除 before 之前的所有记录，返回删除的条数
+func (s *Store) Prune(before time.Time) (int, error) {
+	removed := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		limit := timeKey(before, 0)
+		for _, name := range [][]byte{bucketMeasurements, bucketSelections, bucketDNSChanges} {
+			b := tx.Bucket(name)
+			// 先收集再删除，避免在游标遍历过程中删除导致跳过记录
+			var stale [][]byte
+			c := b.Cursor()
+			for k, _ := c.First(); k != nil && bytes.Compare(k, limit) < 0; k, _ = c.Next() {
+				stale = append(stale, append([]byte(nil), k...))
+			}
+			for _, k := range stale {
+				if err := b.Delete(k); err != nil {
+					return err
+				}
+			}
+			removed += len(stale)
+		}
+		return nil
+	})
+	return removed, err
+}
+
+func (s *Store) scan(bucket []byte, since time.Time, fn func(v []byte) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket)
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, v := c.Seek(timeKey(since, 0)); k != nil; k, v = c.Next() {
+			if err := fn(v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// put 以 "时间戳 + 序号" 作为键写入，保证同一时刻的多条记录不会互相覆盖且按时间有序
+func put(b *bolt.Bucket, at time.Time, v interface{}) error {
+	seq, err := b.NextSequence()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.Put(timeKey(at, seq), data)
+}
+
+func timeKey(t time.Time, seq uint64) []byte {
+	k := make([]byte, 16)
+	// 零值或 1970 年之前的时间统一视为最早时刻
+	var ns int64
+	if !t.IsZero() && t.UnixNano() > 0 {
+		ns = t.UnixNano()
+	}
+	binary.BigEndian.PutUint64(k[:8], uint64(ns))
+	binary.BigEndian.PutUint64(k[8:], seq)
+	return k
+}
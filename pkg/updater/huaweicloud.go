@@ -1,11 +1,13 @@
 package updater
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"time"
 
 	"controller/pkg/config"
+	"controller/pkg/logging"
 	"github.com/huaweicloud/huaweicloud-sdk-go-v3/core/auth/basic"
 	coreCfg "github.com/huaweicloud/huaweicloud-sdk-go-v3/core/config"
 	dns "github.com/huaweicloud/huaweicloud-sdk-go-v3/services/dns/v2"
@@ -14,16 +16,16 @@ import (
 )
 
 // newHuaweiDNSClient remains the same, it correctly creates the client.
-func newHuaweiDNSClient(cfg *config.Config) (*dns.DnsClient, error) {
+func newHuaweiDNSClient(cfg config.Huawei) (*dns.DnsClient, error) {
 	auth := basic.NewCredentialsBuilder().
-		WithAk(cfg.Huawei.AccessKey).
-		WithSk(cfg.Huawei.SecretKey).
-		WithProjectId(cfg.Huawei.ProjectID).
+		WithAk(cfg.AccessKey).
+		WithSk(cfg.SecretKey).
+		WithProjectId(cfg.ProjectID).
 		Build()
 
-	r, err := dnsRegion.SafeValueOf(cfg.Huawei.Region)
+	r, err := dnsRegion.SafeValueOf(cfg.Region)
 	if err != nil {
-		return nil, fmt.Errorf("invalid or unsupported region '%s' specified: %w", cfg.Huawei.Region, err)
+		return nil, fmt.Errorf("invalid or unsupported region '%s' specified: %w", cfg.Region, err)
 	}
 
 	httpConfig := coreCfg.DefaultHttpConfig().
@@ -39,32 +41,73 @@ func newHuaweiDNSClient(cfg *config.Config) (*dns.DnsClient, error) {
 	return client, nil
 }
 
-// UpdateHuaweiCloud has been corrected for the type mismatch.
-func UpdateHuaweiCloud(zoneId, recordsetID, recordName, recordType string, ips []string, cfg *config.Config) error {
-	client, err := newHuaweiDNSClient(cfg)
+// [新增] Huawei 是华为云 DNS 的 Provider 实现
+type Huawei struct {
+	cfg config.Huawei
+}
+
+func NewHuawei(cfg config.Huawei) *Huawei {
+	return &Huawei{cfg: cfg}
+}
+
+func (h *Huawei) Name() string {
+	return config.ProviderHuawei
+}
+
+// UpdateRecordSet has been corrected for the type mismatch.
+// [修改] SDK 不支持 context，仅在调用前检查是否已取消
+func (h *Huawei) UpdateRecordSet(ctx context.Context, rs RecordSet, ips []string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	client, err := newHuaweiDNSClient(h.cfg)
 	if err != nil {
 		return fmt.Errorf("failed to create Huawei Cloud client: %w", err)
 	}
 
+	// [新增] 配置了线路 ID 时先核对记录集所在线路，避免把某运营商的 IP 写进其他线路的记录集
+	if rs.CheckLine && rs.Line != "" {
+		if err := h.checkLine(client, rs); err != nil {
+			return err
+		}
+	}
+
 	// [FIX] Convert the 'int' from the config to 'int32' for the SDK.
-	ttlAsInt32 := int32(cfg.DNS.TTL)
+	ttlAsInt32 := int32(rs.TTL)
 
 	// Build the request object using the corrected type.
 	request := &model.UpdateRecordSetsRequest{}
-	request.ZoneId = zoneId
-	request.RecordsetId = recordsetID
+	request.ZoneId = rs.ZoneID
+	request.RecordsetId = rs.RecordsetID
 	request.Body = &model.UpdateRecordSetsReq{
-		Name:    recordName,
-		Type:    recordType,
+		Name:    rs.Name,
+		Type:    rs.Type,
 		Ttl:     &ttlAsInt32, // Use the address of the newly converted int32 variable.
 		Records: &ips,
 	}
 
-	log.Printf("[info] Updating DNS using 'UpdateRecordSets' for %s (ID: %s) with IPs: %v", recordName, recordsetID, ips)
+	slog.Debug("calling UpdateRecordSets", logging.KeyProvider, h.Name(),
+		"record_name", rs.Name, "recordset_id", rs.RecordsetID, "line", rs.Line, "ips", ips)
 	_, err = client.UpdateRecordSets(request)
 	if err != nil {
 		return fmt.Errorf("failed to call Huawei Cloud UpdateRecordSets API: %w", err)
 	}
 
 	return nil
-}
\ No newline at end of file
+}
+
+// checkLine 查询记录集所在线路，与 rs.Line 不一致时返回错误
+func (h *Huawei) checkLine(client *dns.DnsClient, rs RecordSet) error {
+	resp, err := client.ShowRecordSetWithLine(&model.ShowRecordSetWithLineRequest{ZoneId: rs.ZoneID, RecordsetId: rs.RecordsetID})
+	if err != nil {
+		return fmt.Errorf("failed to call Huawei Cloud ShowRecordSetWithLine API: %w", err)
+	}
+	line := ""
+	if resp.Line != nil {
+		line = *resp.Line
+	}
+	if line != rs.Line {
+		return fmt.Errorf("recordset %s is on line %q, want %q (check the recordset ID and provider_lines)", rs.RecordsetID, line, rs.Line)
+	}
+	return nil
+}
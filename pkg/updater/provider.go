@@ -0,0 +1,38 @@
+package updater
+
+import (
+	"context"
+	"fmt"
+
+	"controller/pkg/config"
+)
+
+// RecordSet 描述服务商中的一个待更新记录集
+type RecordSet struct {
+	ZoneID      string
+	RecordsetID string
+	Name        string // 完整记录名, 以 "." 结尾
+	Type        string // A | AAAA
+	TTL         int
+	Line        string // [新增] 服务商中的线路 ID (如华为云的 Dianxin)
+	CheckLine   bool   // [新增] 为 true 且 Line 非空时，更新前核对记录集所在线路
+}
+
+// Provider 是 DNS 服务商的记录更新接口
+type Provider interface {
+	Name() string
+	// UpdateRecordSet 用 ips 覆盖记录集的全部记录
+	UpdateRecordSet(ctx context.Context, rs RecordSet, ips []string) error
+}
+
+// New 按名称创建服务商，未启用的服务商返回 nil
+func New(name string, cfg *config.Config) (Provider, error) {
+	switch name {
+	case config.ProviderHuawei:
+		if !cfg.Huawei.Enabled {
+			return nil, nil
+		}
+		return NewHuawei(cfg.Huawei), nil
+	}
+	return nil, fmt.Errorf("unknown DNS provider %q", name)
+}